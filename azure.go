@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	azureStorageVersion = "2020-04-08"
+
+	// azureWorkspaceSuffix separates the key of the default workspace
+	// from the name of other workspaces.
+	azureWorkspaceSuffix = "env:"
+)
+
+// AzureSource reads a state stored by Terraform's azurerm backend.
+// Emulators such as Azurite can be used by setting Endpoint.
+type AzureSource struct {
+	StorageAccountName string
+	ContainerName      string
+	Key                string
+	Workspace          string
+
+	// Endpoint is the blob service endpoint, including the account name
+	// for path-style endpoints such as http://127.0.0.1:10000/devstoreaccount1.
+	Endpoint string
+
+	AccessKey string
+	SASToken  string
+
+	Client *http.Client
+}
+
+func init() {
+	RegisterStateSource("azurerm", newAzureSourceFromURL)
+}
+
+// newAzureSourceFromURL creates an AzureSource from a URL such as
+// azurerm://account/container/prod.terraform.tfstate?workspace=staging.
+// The endpoint parameter is also supported. Credentials are read from
+// the environment.
+func newAzureSourceFromURL(u *url.URL) (StateSource, error) {
+	pieces := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if len(pieces) != 2 {
+		return nil, fmt.Errorf("Both a container and key must be specified in %s", u)
+	}
+
+	s := newAzureSourceFromEnv()
+	s.StorageAccountName = u.Host
+	s.ContainerName = pieces[0]
+	s.Key = pieces[1]
+	s.Endpoint = queryDefault(u, "endpoint", s.Endpoint)
+	s.Workspace = queryDefault(u, "workspace", s.Workspace)
+
+	return s, nil
+}
+
+// newAzureSourceFromEnv creates an AzureSource from the environment.
+func newAzureSourceFromEnv() *AzureSource {
+	return &AzureSource{
+		StorageAccountName: os.Getenv("TF_AZURERM_STORAGE_ACCOUNT_NAME"),
+		ContainerName:      os.Getenv("TF_AZURERM_CONTAINER_NAME"),
+		Key:                os.Getenv("TF_AZURERM_KEY"),
+		Workspace:          getWorkspace(),
+		Endpoint:           os.Getenv("TF_AZURERM_ENDPOINT"),
+		AccessKey:          os.Getenv("ARM_ACCESS_KEY"),
+		SASToken:           os.Getenv("ARM_SAS_TOKEN"),
+	}
+}
+
+// BlobName returns the name of the state blob for the configured
+// workspace. Non-default workspaces are stored as <key>env:<workspace>.
+func (s *AzureSource) BlobName() string {
+	if s.Workspace == "" || s.Workspace == defaultWorkspace {
+		return s.Key
+	}
+
+	return s.Key + azureWorkspaceSuffix + s.Workspace
+}
+
+// URL returns the URL of the state blob.
+func (s *AzureSource) URL() (*url.URL, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", s.StorageAccountName)
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/" +
+		url.PathEscape(s.ContainerName) + "/" + uriEncode(s.BlobName(), true))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.SASToken != "" {
+		u.RawQuery = strings.TrimPrefix(s.SASToken, "?")
+	}
+
+	return u, nil
+}
+
+// Fetch downloads the state blob. If the blob does not exist, no bytes
+// and no error are returned.
+func (s *AzureSource) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	meta := StateMetadata{
+		Source:    fmt.Sprintf("azurerm://%s/%s/%s", s.StorageAccountName, s.ContainerName, s.BlobName()),
+		Workspace: s.Workspace,
+	}
+
+	if s.StorageAccountName == "" || s.ContainerName == "" || s.Key == "" {
+		return nil, meta, fmt.Errorf("A storage account name, container name and key must be specified")
+	}
+
+	u, err := s.URL()
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error building Azure Blob URL: %s", err)
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error building Azure Blob request: %s", err)
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("x-ms-version", azureStorageVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+
+	if s.AccessKey != "" && s.SASToken == "" {
+		if err := signSharedKey(req, s.StorageAccountName, s.AccessKey); err != nil {
+			return nil, meta, err
+		}
+	}
+
+	b, err := fetchObject(s.Client, req, meta.Source)
+	return b, meta, err
+}
+
+// signSharedKey signs a request to the blob service with a storage
+// account access key. Only requests without a body are supported.
+func signSharedKey(req *http.Request, account, accessKey string) error {
+	key, err := base64.StdEncoding.DecodeString(accessKey)
+	if err != nil {
+		return fmt.Errorf("Invalid storage account access key: %s", err)
+	}
+
+	var msHeaders []string
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-ms-") {
+			msHeaders = append(msHeaders, k+":"+strings.TrimSpace(strings.Join(v, ",")))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + account + req.URL.EscapedPath()
+
+	q := req.URL.Query()
+	var params []string
+	for k := range q {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		v := q[k]
+		sort.Strings(v)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(v, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		"", // Content-Length
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", account, signature))
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureV012_fetch(t *testing.T) {
+	b, err := ioutil.ReadFile("fixtures/v012/nbering-ansible/terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessKey := base64.StdEncoding.EncodeToString([]byte("secret"))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Sign the received request again and compare the signatures.
+		authorization := r.Header.Get("Authorization")
+		r.Header.Del("Authorization")
+		if err := signSharedKey(r, "devstoreaccount1", accessKey); err != nil || r.Header.Get("Authorization") != authorization {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/devstoreaccount1/tfstate/prod.terraform.tfstateenv:staging":
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	s := &AzureSource{
+		StorageAccountName: "devstoreaccount1",
+		ContainerName:      "tfstate",
+		Key:                "prod.terraform.tfstate",
+		Workspace:          "staging",
+		Endpoint:           ts.URL + "/devstoreaccount1",
+		AccessKey:          accessKey,
+	}
+
+	actual, _, err := s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := parseState(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedStateV012, state)
+
+	s.Workspace = defaultWorkspace
+	actual, _, err = s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, actual)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	cacheDirName = "terraform-inventory"
+
+	// cacheLockInterval is the time between attempts to acquire the lock
+	// of a key held by another process.
+	cacheLockInterval = 50 * time.Millisecond
+)
+
+// errStateChanged is returned when a state was changed since it was cached.
+var errStateChanged = errors.New("The state was changed")
+
+// Cache stores built inventories on disk, so that repeated invocations
+// within the TTL do not have to read the states again. Entries are
+// written to a temporary file and renamed, and a lock file serializes
+// concurrent processes building the same inventory.
+//
+// If Key is set, entries are encrypted with AES-GCM, since inventories
+// may contain secrets such as ansible_become_pass.
+type Cache struct {
+	Dir string
+	TTL time.Duration
+	Key []byte
+}
+
+// cacheEntry is the content of a cache file.
+type cacheEntry struct {
+	Created   time.Time       `json:"created"`
+	Inventory string          `json:"inventory"`
+	Revisions []StateRevision `json:"revisions"`
+}
+
+// getCache returns the inventory cache, or nil if it is disabled. The
+// cache is enabled by setting a TTL with --cache-ttl or
+// TF_INVENTORY_CACHE_TTL. Its directory can be set with
+// TF_INVENTORY_CACHE_DIR.
+func getCache() (*Cache, error) {
+	ttl := *cacheTTL
+	if ttl == 0 {
+		if v := os.Getenv("TF_INVENTORY_CACHE_TTL"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("Invalid TF_INVENTORY_CACHE_TTL: %s", v)
+			}
+			ttl = d
+		}
+	}
+
+	if ttl <= 0 {
+		return nil, nil
+	}
+
+	dir := os.Getenv("TF_INVENTORY_CACHE_DIR")
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("Error determining cache directory: %s", err)
+		}
+		dir = filepath.Join(base, cacheDirName)
+	}
+
+	key, err := getCacheKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{Dir: dir, TTL: ttl, Key: key}, nil
+}
+
+// getRefresh reports whether the cache should be bypassed, given with
+// --refresh or TF_INVENTORY_REFRESH.
+func getRefresh() bool {
+	return *refresh || os.Getenv("TF_INVENTORY_REFRESH") != ""
+}
+
+// inventoryCacheKey identifies an inventory by everything which selects
+// the states it is built from: the working directory, the state directory
+// or backend configured through the environment, the workspace, the
+// command and the flags.
+func inventoryCacheKey() (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("Error determining directory: %s", err)
+	}
+
+	template, err := getCommandTemplate()
+	if err != nil {
+		return "", err
+	}
+
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if !strings.HasPrefix(strings.TrimLeft(arg, "-"), "refresh") {
+			args = append(args, arg)
+		}
+	}
+
+	key := struct {
+		Dir       string
+		Workspace string
+		Command   []string
+		Args      []string
+		Env       []string
+	}{
+		Dir:       cwd,
+		Workspace: getWorkspace(),
+		Command:   append([]string{command}, template...),
+		Args:      args,
+		Env:       backendEnviron(),
+	}
+
+	b, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// backendEnviron returns the sorted environment variables which may
+// configure the state sources. The variables of the cache itself and the
+// other variables which do not change the inventory are left out.
+func backendEnviron() []string {
+	var env []string
+
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "TF_") && !strings.HasPrefix(v, "PG_") &&
+			!strings.HasPrefix(v, "CONSUL_") {
+			continue
+		}
+
+		if strings.HasPrefix(v, "TF_INVENTORY_CACHE_") ||
+			strings.HasPrefix(v, "TF_INVENTORY_REFRESH=") ||
+			strings.HasPrefix(v, "TF_INVENTORY_DEBUG=") ||
+			strings.HasPrefix(v, "TF_LOG") {
+			continue
+		}
+
+		env = append(env, v)
+	}
+
+	sort.Strings(env)
+	return env
+}
+
+// path returns the path of the cache file of a key.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+// Lock acquires an exclusive lock on a key, waiting for other processes
+// which hold it until the context is done. The returned function releases
+// the lock.
+func (c *Cache) Lock(ctx context.Context, key string) (func(), error) {
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("Error creating cache directory: %s", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(c.Dir, key+".lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening cache lock: %s", err)
+	}
+
+	for {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Error locking cache: %s", err)
+		}
+
+		if ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("Error locking cache: %s", ctx.Err())
+		case <-time.After(cacheLockInterval):
+		}
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// Get returns the cached entry of a key, if it exists, and whether it has
+// not expired yet.
+func (c *Cache) Get(key string) (*cacheEntry, bool) {
+	b, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	if c.Key != nil {
+		b, err = decrypt(c.Key, b, []byte(key))
+		if err != nil {
+			debugf("Ignoring cache file %s: %s", c.path(key), err)
+			return nil, false
+		}
+	}
+
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		debugf("Ignoring invalid cache file %s: %s", c.path(key), err)
+		return nil, false
+	}
+
+	return &e, time.Since(e.Created) <= c.TTL
+}
+
+// Put stores the inventory of a key along with the revisions of the states
+// it was built from. The file is replaced atomically, so that other
+// processes never read a partially written file.
+func (c *Cache) Put(key string, inventory string, revisions []StateRevision) error {
+	b, err := json.Marshal(cacheEntry{
+		Created:   time.Now(),
+		Inventory: inventory,
+		Revisions: revisions,
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.Key != nil {
+		b, err = encrypt(c.Key, b, []byte(key))
+		if err != nil {
+			return fmt.Errorf("Error encrypting cache: %s", err)
+		}
+	}
+
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return fmt.Errorf("Error creating cache directory: %s", err)
+	}
+
+	// The temporary file is only readable by the user, which the
+	// renamed file keeps.
+	f, err := ioutil.TempFile(c.Dir, key+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error writing cache: %s", err)
+	}
+
+	err = f.Chmod(0600)
+	if err == nil {
+		_, err = f.Write(b)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Error writing cache: %s", err)
+	}
+
+	return nil
+}
+
+// allRevisionSources reports whether the sources of all requests can fetch
+// the revisions of their states.
+func allRevisionSources(requests []stateRequest) bool {
+	for _, r := range requests {
+		if _, ok := r.Source.(RevisionSource); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// revisionsUnchanged reports whether the states still have the given
+// revisions. This is only known if every source can fetch the revision of
+// its state without fetching the state itself.
+func revisionsUnchanged(ctx context.Context, revisions []StateRevision) bool {
+	if len(revisions) == 0 {
+		return false
+	}
+
+	limit, err := getParallelism()
+	if err != nil {
+		return false
+	}
+
+	// The workspaces are only listed if the sources can fetch revisions,
+	// as listing them may run a command for each source.
+	sources, err := getStateRequests()
+	if err != nil || !allRevisionSources(sources) {
+		return false
+	}
+
+	requests, err := expandWorkspaces(ctx, sources, limit)
+	if err != nil || len(requests) != len(revisions) || !allRevisionSources(requests) {
+		return false
+	}
+
+	err = forEach(ctx, len(requests), limit, func(ctx context.Context, i int) error {
+		var (
+			current StateRevision
+			found   bool
+		)
+
+		err := retryPolicy.Do(ctx, func() error {
+			var err error
+			current, found, err = requests[i].Source.(RevisionSource).FetchRevision(ctx)
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if !found || !current.Matches(revisions[i]) {
+			return errStateChanged
+		}
+
+		return nil
+	})
+
+	if err != nil && err != errStateChanged {
+		debugf("Unable to check the revisions of the cached inventory: %s", err)
+	}
+
+	return err == nil
+}
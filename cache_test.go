@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCache(t *testing.T, ttl time.Duration) (*Cache, func()) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Cache{Dir: dir, TTL: ttl}, func() { os.RemoveAll(dir) }
+}
+
+func TestCache(t *testing.T) {
+	cache, cleanup := newTestCache(t, time.Minute)
+	defer cleanup()
+
+	e, _ := cache.Get("key")
+	assert.Nil(t, e)
+
+	revisions := []StateRevision{{Serial: 12, Lineage: "9b8b06a2-914e-26df-58d1-c5146f4d8e25"}}
+	if err := cache.Put("key", `{"all":{}}`, revisions); err != nil {
+		t.Fatal(err)
+	}
+
+	e, fresh := cache.Get("key")
+	assert.True(t, fresh)
+	assert.Equal(t, `{"all":{}}`, e.Inventory)
+	assert.Equal(t, revisions, e.Revisions)
+
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(cache.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, files, 1)
+
+	cache.TTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+
+	e, fresh = cache.Get("key")
+	assert.NotNil(t, e)
+	assert.False(t, fresh)
+}
+
+func TestCache_lock(t *testing.T) {
+	cache, cleanup := newTestCache(t, time.Minute)
+	defer cleanup()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		builds int
+	)
+
+	// Only the first of the concurrent builds reads the state, the
+	// others wait for it and use its result.
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			unlock, err := cache.Lock(context.Background(), "key")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer unlock()
+
+			if _, fresh := cache.Get("key"); fresh {
+				return
+			}
+
+			mu.Lock()
+			builds++
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+			if err := cache.Put("key", "{}", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, 1, builds)
+}
+
+func TestCache_lockTimeout(t *testing.T) {
+	cache, cleanup := newTestCache(t, time.Minute)
+	defer cleanup()
+
+	unlock, err := cache.Lock(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	// A lock held by a hung process is given up on once the inventory
+	// times out.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = cache.Lock(ctx, "key")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "deadline exceeded")
+	}
+}
+
+func TestInventoryCacheKey(t *testing.T) {
+	defer setenv("TF_WORKSPACE", "staging")()
+
+	staging, err := inventoryCacheKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("TF_INVENTORY_REFRESH", "1")
+	refreshed, err := inventoryCacheKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Unsetenv("TF_INVENTORY_REFRESH")
+
+	os.Setenv("TF_WORKSPACE", "production")
+	production, err := inventoryCacheKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, staging, refreshed)
+	assert.NotEqual(t, staging, production)
+}
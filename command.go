@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// killGracePeriod is the time a command is given to exit after it was
+// terminated, before it is killed.
+const killGracePeriod = 5 * time.Second
+
+func init() {
+	RegisterStateSource("cmd", newCommandSourceFromURL)
+}
+
+// CommandSource obtains a state by running `terraform state pull`, or an
+// equivalent command such as `terragrunt state pull`, in a directory.
+//
+// If Template is set, it is run instead, such as
+// `aws-vault exec prod -- terraform state pull`.
+type CommandSource struct {
+	Command   string
+	Template  []string
+	Dir       string
+	Workspace string
+}
+
+// newCommandSourceFromURL creates a CommandSource from a URL such as
+// cmd://./infra?command=terragrunt&workspace=staging. The template
+// parameter sets the command line to run.
+func newCommandSourceFromURL(u *url.URL) (StateSource, error) {
+	dir := u.Host + u.Path
+	if u.Opaque != "" {
+		dir = u.Opaque
+	}
+
+	if dir == "" {
+		dir = "."
+	}
+
+	template, err := splitCommandLine(u.Query().Get("template"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &CommandSource{
+		Command:   queryDefault(u, "command", command),
+		Template:  template,
+		Dir:       dir,
+		Workspace: u.Query().Get("workspace"),
+	}, nil
+}
+
+// Fetch runs the command and returns its output.
+func (s *CommandSource) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	meta := StateMetadata{
+		Source:    s.Dir,
+		Workspace: s.Workspace,
+	}
+
+	args := s.pullArgs()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = s.Dir
+
+	if s.Workspace != "" {
+		cmd.Env = append(environWithout("TF_WORKSPACE"), "TF_WORKSPACE="+s.Workspace)
+	}
+
+	b, err := runCommand(ctx, cmd)
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error running `%s` in directory %s, %s\n", strings.Join(args, " "), s.Dir, err)
+	}
+
+	return b, meta, nil
+}
+
+// ListWorkspaces runs `terraform workspace list` and returns the names of
+// all workspaces.
+func (s *CommandSource) ListWorkspaces(ctx context.Context) ([]string, error) {
+	args, err := s.workspaceListArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = s.Dir
+
+	// TF_WORKSPACE may hold a list of workspaces which Terraform would
+	// reject as the current workspace.
+	cmd.Env = environWithout("TF_WORKSPACE")
+
+	out, err := runCommand(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("Error running `%s` in directory %s, %s\n", strings.Join(args, " "), s.Dir, err)
+	}
+
+	return parseWorkspaceList(string(out)), nil
+}
+
+// ForWorkspace returns a CommandSource for the state of another
+// workspace.
+func (s *CommandSource) ForWorkspace(workspace string) StateSource {
+	c := *s
+	c.Workspace = workspace
+	return &c
+}
+
+// runCommand runs cmd in a process group of its own and returns its
+// output. When ctx is done, the process group is terminated, and killed if
+// it does not exit within killGracePeriod. The error includes the output
+// of the command on stderr.
+func runCommand(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			terminateProcessGroup(cmd)
+		case <-done:
+			return
+		}
+
+		select {
+		case <-time.After(killGracePeriod):
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+
+	if err != nil {
+		// A command which finished in time is not failed by a
+		// deadline which expired right after.
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			err = fmt.Errorf("timed out")
+		case context.Canceled:
+			err = fmt.Errorf("interrupted")
+		}
+
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// pullArgs returns the command line which pulls the state.
+func (s *CommandSource) pullArgs() []string {
+	if len(s.Template) > 0 {
+		return s.Template
+	}
+
+	return []string{s.Command, "state", "pull"}
+}
+
+// workspaceListArgs returns the command line which lists the workspaces.
+// A template is only supported if it ends with `state pull`, which is
+// replaced with `workspace list`.
+func (s *CommandSource) workspaceListArgs() ([]string, error) {
+	if len(s.Template) == 0 {
+		return []string{s.Command, "workspace", "list"}, nil
+	}
+
+	n := len(s.Template)
+	if n < 3 || s.Template[n-2] != "state" || s.Template[n-1] != "pull" {
+		return nil, fmt.Errorf("Listing workspaces requires the command template to end with `state pull`: %s", strings.Join(s.Template, " "))
+	}
+
+	args := append([]string{}, s.Template[:n-2]...)
+	return append(args, "workspace", "list"), nil
+}
+
+// getCommandTemplate returns the command line which pulls the state, given
+// with --command or TF_INVENTORY_COMMAND, such as
+// `aws-vault exec prod -- terraform state pull`.
+func getCommandTemplate() ([]string, error) {
+	v := *commandTemplate
+	if v == "" {
+		v = os.Getenv("TF_INVENTORY_COMMAND")
+	}
+
+	return splitCommandLine(v)
+}
+
+// splitCommandLine splits a command line into its arguments like a shell.
+// Arguments are separated by whitespace and may be quoted with single or
+// double quotes. A backslash escapes the next character outside of single
+// quotes. Variables and other shell features are not supported.
+func splitCommandLine(s string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("Invalid command line: %s", s)
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+// getCommand returns the command which reads the state. TF_INVENTORY_BINARY
+// selects a command, such as /opt/bin/tofu, and TF_TERRAGRUNT selects
+// Terragrunt. Otherwise Terraform is used, or OpenTofu if only OpenTofu is
+// installed.
+func getCommand() string {
+	if v := os.Getenv("TF_INVENTORY_BINARY"); v != "" {
+		return v
+	}
+
+	if os.Getenv("TF_TERRAGRUNT") != "" {
+		return Terragrunt
+	}
+
+	for _, c := range []string{Terraform, OpenTofu} {
+		if _, err := exec.LookPath(c); err == nil {
+			return c
+		}
+	}
+
+	return Terraform
+}
+
+// commandName returns the name of a command without its directory and
+// extension, such as tofu for /usr/local/bin/tofu.exe.
+func commandName(command string) string {
+	name := filepath.Base(command)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// parseWorkspaceList parses the output of `terraform workspace list`. The
+// current workspace is marked with an asterisk.
+func parseWorkspaceList(out string) []string {
+	var workspaces []string
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "*"))
+		if line != "" {
+			workspaces = append(workspaces, line)
+		}
+	}
+
+	return workspaces
+}
+
+// environWithout returns the environment without the given variable.
+func environWithout(key string) []string {
+	var env []string
+
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, key+"=") {
+			env = append(env, v)
+		}
+	}
+
+	return env
+}
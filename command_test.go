@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, OpenTofu), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setenv("PATH", dir)()
+	defer setenv("TF_INVENTORY_BINARY", "")()
+	defer setenv("TF_TERRAGRUNT", "")()
+
+	// Only OpenTofu is installed.
+	assert.Equal(t, OpenTofu, getCommand())
+
+	os.Setenv("TF_TERRAGRUNT", "1")
+	assert.Equal(t, Terragrunt, getCommand())
+
+	os.Setenv("TF_INVENTORY_BINARY", "/opt/terragrunt/bin/terragrunt")
+	assert.Equal(t, "/opt/terragrunt/bin/terragrunt", getCommand())
+	assert.True(t, isTerragrunt(getCommand()))
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := map[string][]string{
+		"": nil,
+		"aws-vault exec prod -- terraform state pull":             {"aws-vault", "exec", "prod", "--", "terraform", "state", "pull"},
+		`sops exec-env 'secrets file.enc' "terraform state pull"`: {"sops", "exec-env", "secrets file.enc", "terraform state pull"},
+		`echo a\ b "c\"d" ''`:                                     {"echo", "a b", `c"d`, ""},
+	}
+
+	for line, expected := range tests {
+		actual, err := splitCommandLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, actual, line)
+	}
+
+	_, err := splitCommandLine(`terraform "state pull`)
+	assert.Error(t, err)
+}
+
+func TestCommandSource_workspaceListArgs(t *testing.T) {
+	s := &CommandSource{Template: []string{"aws-vault", "exec", "prod", "--", "terraform", "state", "pull"}}
+
+	args, err := s.workspaceListArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{"aws-vault", "exec", "prod", "--", "terraform", "workspace", "list"}, args)
+
+	s.Template = []string{"./pull-state.sh"}
+	_, err = s.workspaceListArgs()
+	assert.Error(t, err)
+}
+
+func TestCommandSourceV012_template(t *testing.T) {
+	source := &CommandSource{
+		Template: []string{"cat", "terraform.tfstate"},
+		Dir:      "fixtures/v012/nbering-ansible",
+	}
+
+	actual, err := getStateFromSource(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedStateV012, actual)
+}
+
+// setenv sets an environment variable and returns a function which
+// restores its previous value.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandSource_stderr(t *testing.T) {
+	source := &CommandSource{
+		Template: []string{"sh", "-c", "echo 'Error acquiring the state lock' >&2; exit 1"},
+		Dir:      ".",
+	}
+
+	_, _, err := source.Fetch(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exit status 1: Error acquiring the state lock")
+	}
+}
+
+func TestCommandSource_timeout(t *testing.T) {
+	// The background process keeps stdout open, so the command only
+	// returns once its whole process group is terminated.
+	source := &CommandSource{
+		Template: []string{"sh", "-c", "sleep 30 & sleep 30; wait"},
+		Dir:      ".",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := source.Fetch(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "timed out")
+	}
+
+	assert.True(t, time.Since(start) < killGracePeriod, "the command should be terminated")
+}
+
+// expiredContext is a context whose deadline expired without its Done
+// channel being closed, as when the deadline passes while the command is
+// exiting.
+type expiredContext struct {
+	context.Context
+}
+
+func (expiredContext) Err() error {
+	return context.DeadlineExceeded
+}
+
+func TestRunCommand_finishedAtDeadline(t *testing.T) {
+	out, err := runCommand(expiredContext{context.Background()}, exec.Command("echo", "{}"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{}\n", string(out))
+}
+
+func TestRevisionsUnchanged_commandSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revisions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The command records each call, which would list the workspaces.
+	marker := filepath.Join(dir, "called")
+	defer setenv("TF_INVENTORY_COMMAND", "sh -c 'touch "+marker+"' state pull")()
+	defer setenv("TF_STATE", dir)()
+	defer setenv("TF_WORKSPACES", "*")()
+
+	assert.False(t, revisionsUnchanged(context.Background(), []StateRevision{{Serial: 1}}))
+
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err), "the workspaces should not be listed")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultConsulAddress = "127.0.0.1:8500"
+
+	// consulWorkspaceSuffix separates the path of the default workspace
+	// from the name of other workspaces.
+	consulWorkspaceSuffix = "-env:"
+)
+
+// ConsulSource reads a state stored by Terraform's consul backend.
+type ConsulSource struct {
+	Address    string
+	Scheme     string
+	Token      string
+	Datacenter string
+	Path       string
+	Workspace  string
+
+	Client *http.Client
+}
+
+func init() {
+	RegisterStateSource("consul", newConsulSourceFromURL)
+}
+
+// newConsulSourceFromURL creates a ConsulSource from a URL such as
+// consul://127.0.0.1:8500/path/to/state?workspace=staging. The scheme,
+// datacenter and token parameters are also supported.
+func newConsulSourceFromURL(u *url.URL) (StateSource, error) {
+	s := newConsulSourceFromEnv()
+	if u.Host != "" {
+		s.Address = u.Host
+	}
+	s.Path = strings.TrimPrefix(u.Path, "/")
+	s.Scheme = queryDefault(u, "scheme", s.Scheme)
+	s.Datacenter = queryDefault(u, "datacenter", s.Datacenter)
+	s.Token = queryDefault(u, "token", s.Token)
+	s.Workspace = queryDefault(u, "workspace", s.Workspace)
+
+	return s, nil
+}
+
+// newConsulSourceFromEnv creates a ConsulSource from the environment.
+func newConsulSourceFromEnv() *ConsulSource {
+	s := &ConsulSource{
+		Address:    os.Getenv("CONSUL_HTTP_ADDR"),
+		Scheme:     "http",
+		Token:      os.Getenv("CONSUL_HTTP_TOKEN"),
+		Datacenter: os.Getenv("TF_CONSUL_DATACENTER"),
+		Path:       os.Getenv("TF_CONSUL_PATH"),
+		Workspace:  getWorkspace(),
+	}
+
+	if s.Address == "" {
+		s.Address = defaultConsulAddress
+	}
+
+	if v, err := strconv.ParseBool(os.Getenv("CONSUL_HTTP_SSL")); err == nil && v {
+		s.Scheme = "https"
+	}
+
+	return s
+}
+
+// StatePath returns the key of the state for the configured workspace.
+func (s *ConsulSource) StatePath() string {
+	if s.Workspace == "" || s.Workspace == defaultWorkspace {
+		return s.Path
+	}
+
+	return s.Path + consulWorkspaceSuffix + s.Workspace
+}
+
+// Fetch reads the state from the KV store. Both gzip compressed and
+// chunked states are supported. If no state exists, no bytes and no
+// error are returned.
+func (s *ConsulSource) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	meta := StateMetadata{
+		Source:    fmt.Sprintf("consul://%s/%s", s.Address, s.StatePath()),
+		Workspace: s.Workspace,
+	}
+
+	if s.Path == "" {
+		return nil, meta, fmt.Errorf("A Consul path must be specified")
+	}
+
+	payload, err := s.get(ctx, s.StatePath())
+	if err != nil {
+		return nil, meta, err
+	}
+
+	if payload == nil {
+		return nil, meta, nil
+	}
+
+	// Large states are split into chunks. The state path then holds the
+	// list of chunks and the MD5 sum of the complete payload.
+	var chunked struct {
+		CurrentHash string   `json:"current-hash"`
+		Chunks      []string `json:"chunks"`
+	}
+
+	var hash string
+	if err := json.Unmarshal(payload, &chunked); err == nil && chunked.CurrentHash != "" {
+		hash = chunked.CurrentHash
+		payload = nil
+
+		for _, c := range chunked.Chunks {
+			v, err := s.get(ctx, c)
+			if err != nil {
+				return nil, meta, err
+			}
+
+			if v == nil {
+				return nil, meta, fmt.Errorf("Unable to find state chunk %s", c)
+			}
+
+			payload = append(payload, v...)
+		}
+	}
+
+	// A payload starting with 0x1f is gzip compressed.
+	if len(payload) > 0 && payload[0] == 0x1f {
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, meta, fmt.Errorf("Error decompressing state: %s", err)
+		}
+
+		payload, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, meta, fmt.Errorf("Error decompressing state: %s", err)
+		}
+	}
+
+	if hash != "" && fmt.Sprintf("%x", md5.Sum(payload)) != hash {
+		return nil, meta, fmt.Errorf("The state at %s does not match its hash %s", s.StatePath(), hash)
+	}
+
+	return payload, meta, nil
+}
+
+// get returns the raw value of a key. If the key does not exist, no bytes
+// and no error are returned.
+func (s *ConsulSource) get(ctx context.Context, key string) ([]byte, error) {
+	addr := s.Address
+	if !strings.Contains(addr, "://") {
+		addr = s.Scheme + "://" + addr
+	}
+
+	q := url.Values{}
+	q.Set("raw", "")
+	if s.Datacenter != "" {
+		q.Set("dc", s.Datacenter)
+	}
+
+	u := fmt.Sprintf("%s/v1/kv/%s?%s", strings.TrimSuffix(addr, "/"),
+		strings.TrimPrefix(key, "/"), q.Encode())
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error building Consul request: %s", err)
+	}
+	req = req.WithContext(ctx)
+
+	if s.Token != "" {
+		req.Header.Set("X-Consul-Token", s.Token)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s from Consul: %s", key, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s from Consul: %s", key, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp, b, "Error reading %s from Consul", key)
+	}
+
+	return b, nil
+}
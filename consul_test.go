@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsulV012_fetch(t *testing.T) {
+	b, err := ioutil.ReadFile("fixtures/v012/nbering-ansible/terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(b)
+	w.Close()
+
+	// Split the compressed state into chunks like the consul backend
+	// does for large states.
+	hash := fmt.Sprintf("%x", md5.Sum(b))
+	compressed := gz.Bytes()
+	half := len(compressed) / 2
+	chunks := fmt.Sprintf(`{"current-hash": "%s", "chunks": ["state/chunked/tfstate/%s/0", "state/chunked/tfstate/%s/1"]}`,
+		hash, hash, hash)
+
+	kv := map[string][]byte{
+		"state/plain":                          b,
+		"state/plain-env:staging":              compressed,
+		"state/chunked":                        []byte(chunks),
+		"state/chunked/tfstate/" + hash + "/0": compressed[:half],
+		"state/chunked/tfstate/" + hash + "/1": compressed[half:],
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consul-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if _, ok := r.URL.Query()["raw"]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		v, ok := kv[strings.TrimPrefix(r.URL.Path, "/v1/kv/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write(v)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path      string
+		workspace string
+	}{
+		{"state/plain", defaultWorkspace},
+		{"state/plain", "staging"},
+		{"state/chunked", defaultWorkspace},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path+"/"+test.workspace, func(t *testing.T) {
+			s := &ConsulSource{
+				Address:   ts.URL,
+				Token:     "token",
+				Path:      test.path,
+				Workspace: test.workspace,
+			}
+
+			actual, _, err := s.Fetch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state, err := parseState(actual)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, expectedStateV012, state)
+		})
+	}
+
+	s := &ConsulSource{
+		Address: ts.URL,
+		Token:   "token",
+		Path:    "state/missing",
+	}
+
+	actual, _, err := s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, actual)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// cacheKeySize is the size of the AES-256 key of the cache.
+const cacheKeySize = 32
+
+// getCacheKey returns the key which encrypts the cache, or nil if the
+// cache is not encrypted. The key is given base64-encoded in
+// TF_INVENTORY_CACHE_KEY or in the file TF_INVENTORY_CACHE_KEY_FILE.
+// With --cache-encrypt or TF_INVENTORY_CACHE_ENCRYPT, a key is required.
+func getCacheKey() ([]byte, error) {
+	v := os.Getenv("TF_INVENTORY_CACHE_KEY")
+	name := "TF_INVENTORY_CACHE_KEY"
+
+	if v == "" {
+		if file := os.Getenv("TF_INVENTORY_CACHE_KEY_FILE"); file != "" {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading the cache key: %s", err)
+			}
+
+			v = string(b)
+			name = file
+		}
+	}
+
+	if v == "" {
+		if *cacheEncrypt || os.Getenv("TF_INVENTORY_CACHE_ENCRYPT") != "" {
+			return nil, fmt.Errorf("The inventory cache is encrypted, but no key was given. " +
+				"Set TF_INVENTORY_CACHE_KEY or TF_INVENTORY_CACHE_KEY_FILE to a base64-encoded " +
+				"32 byte key, such as the output of `openssl rand -base64 32`")
+		}
+
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil || len(key) != cacheKeySize {
+		return nil, fmt.Errorf("Invalid cache key in %s: it must be a base64-encoded %d byte key", name, cacheKeySize)
+	}
+
+	return key, nil
+}
+
+// encrypt encrypts b with AES-GCM. The random nonce is prepended to the
+// result. The additional data is authenticated but not encrypted.
+func encrypt(key, b, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, b, additionalData), nil
+}
+
+// decrypt decrypts b, which was encrypted by encrypt.
+func decrypt(key, b, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < gcm.NonceSize() {
+		return nil, errors.New("The encrypted data is too short")
+	}
+
+	nonce, ciphertext := b[:gcm.NonceSize()], b[gcm.NonceSize():]
+
+	b, err = gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, errors.New("Unable to decrypt, the key may have changed")
+	}
+
+	return b, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
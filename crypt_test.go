@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testCacheKey = bytes.Repeat([]byte{0x42}, cacheKeySize)
+
+func TestEncrypt(t *testing.T) {
+	b, err := encrypt(testCacheKey, []byte("secret"), []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotContains(t, string(b), "secret")
+
+	actual, err := decrypt(testCacheKey, b, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "secret", string(actual))
+
+	_, err = decrypt(testCacheKey, b, []byte("other"))
+	assert.Error(t, err)
+
+	_, err = decrypt(bytes.Repeat([]byte{0x43}, cacheKeySize), b, []byte("key"))
+	assert.Error(t, err)
+}
+
+func TestGetCacheKey(t *testing.T) {
+	defer setenv("TF_INVENTORY_CACHE_KEY", "")()
+	defer setenv("TF_INVENTORY_CACHE_KEY_FILE", "")()
+	defer setenv("TF_INVENTORY_CACHE_ENCRYPT", "")()
+
+	key, err := getCacheKey()
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+
+	os.Setenv("TF_INVENTORY_CACHE_ENCRYPT", "1")
+	_, err = getCacheKey()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no key was given")
+	}
+
+	f, err := ioutil.TempFile("", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(base64.StdEncoding.EncodeToString(testCacheKey) + "\n")
+	f.Close()
+
+	os.Setenv("TF_INVENTORY_CACHE_KEY_FILE", f.Name())
+	key, err = getCacheKey()
+	assert.NoError(t, err)
+	assert.Equal(t, testCacheKey, key)
+
+	os.Setenv("TF_INVENTORY_CACHE_KEY", "c2hvcnQ=")
+	_, err = getCacheKey()
+	assert.Error(t, err)
+}
+
+func TestCache_encrypted(t *testing.T) {
+	cache, cleanup := newTestCache(t, time.Minute)
+	defer cleanup()
+
+	cache.Key = testCacheKey
+
+	inventory := `{"_meta":{"hostvars":{"web":{"ansible_become_pass":"hunter2"}}}}`
+	if err := cache.Put("key", inventory, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(cache.path("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	b, err := ioutil.ReadFile(cache.path("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(b), "hunter2")
+
+	e, fresh := cache.Get("key")
+	assert.True(t, fresh)
+	assert.Equal(t, inventory, e.Inventory)
+
+	// Entries cannot be read with another key.
+	cache.Key = bytes.Repeat([]byte{0x43}, cacheKeySize)
+	e, _ = cache.Get("key")
+	assert.Nil(t, e)
+}
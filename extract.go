@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// maxSnippetLength is the length of the output shown when it does not
+// contain a state.
+const maxSnippetLength = 200
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|\x1b\][^\x07]*\x07`)
+
+// extractState locates and decodes the state in the output of a command,
+// which may also contain banners, log lines and ANSI colour codes. The
+// state is the first JSON object with a version. The lines of the output
+// before and after the state are returned as skipped.
+func extractState(b []byte) (state State, skipped []string, err error) {
+	// A valid state cannot contain an escape character, since JSON
+	// strings encode it as \u001b.
+	if bytes.IndexByte(b, 0x1b) >= 0 {
+		b = ansiEscape.ReplaceAll(b, nil)
+	}
+
+	for i := 0; i < len(b); {
+		j := bytes.IndexByte(b[i:], '{')
+		if j < 0 {
+			break
+		}
+
+		start := i + j
+		state, n, err := decodeState(b[start:])
+
+		switch err.(type) {
+		case nil:
+			end := start + n
+			skipped = append(nonEmptyLines(b[:start]), nonEmptyLines(b[end:])...)
+			return state, skipped, nil
+		case stateError:
+			return nil, nil, err
+		}
+
+		switch err {
+		case io.ErrUnexpectedEOF:
+			return nil, nil, fmt.Errorf("The state is incomplete: %s", snippet(b[start:]))
+		case errNotState:
+			// Other JSON objects, such as structured log lines,
+			// are skipped.
+			i = start + n
+		default:
+			i = start + 1
+		}
+	}
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, nil, nil
+	}
+
+	return nil, nil, fmt.Errorf("No state found in the output: %s", snippet(b))
+}
+
+// nonEmptyLines returns the lines of b which are not blank.
+func nonEmptyLines(b []byte) []string {
+	var lines []string
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// snippet returns the beginning of the output for an error message.
+func snippet(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if len(s) > maxSnippetLength {
+		s = s[:maxSnippetLength] + "..."
+	}
+
+	return fmt.Sprintf("%q", s)
+}
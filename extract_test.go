@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractState(t *testing.T) {
+	state := `{"version": 4, "terraform_version": "1.5.0", "resources": [{"type": "ansible_host"}]}`
+
+	out := strings.Join([]string{
+		"\x1b[0m\x1b[1mterragrunt version v0.45.0\x1b[0m",
+		`{"@level":"info","@message":"Terraform 1.5.0"}`,
+		`{"version":null}`,
+		"INFO[0000] Downloading Terraform configurations {cache}",
+		"o:" + state,
+		"\x1b[32mDone\x1b[0m",
+	}, "\n")
+
+	actual, skipped, err := extractState([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, StateV012{Resources: []ResourceV012{{Type: "ansible_host"}}}, actual)
+	assert.Equal(t, []string{
+		"terragrunt version v0.45.0",
+		`{"@level":"info","@message":"Terraform 1.5.0"}`,
+		`{"version":null}`,
+		"INFO[0000] Downloading Terraform configurations {cache}",
+		"o:",
+		"Done",
+	}, skipped)
+
+	actual, skipped, err = extractState([]byte("\n  \n"))
+	assert.NoError(t, err)
+	assert.Nil(t, actual)
+	assert.Nil(t, skipped)
+
+	_, _, err = extractState([]byte("Error: no credentials\n"))
+	assert.Error(t, err)
+
+	_, _, err = extractState([]byte(`{"version": 5}`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported state version 5")
+	}
+
+	_, _, err = extractState([]byte(state[:30]))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "incomplete")
+	}
+}
+
+func TestExtractStateV012_noisy(t *testing.T) {
+	b, err := ioutil.ReadFile("fixtures/v012/nbering-ansible/terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noisy := "Acquiring state lock. This may take a few moments...\n" + string(b) + "\nReleasing state lock.\n"
+	source := &ReaderSource{Name: "noisy", Reader: strings.NewReader(noisy)}
+
+	actual, err := getStateFromSource(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedStateV012, actual)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGCSEndpoint = "https://storage.googleapis.com"
+	defaultGCSTokenURI = "https://oauth2.googleapis.com/token"
+
+	// gcsReadScope is the OAuth 2.0 scope requested for the credentials.
+	gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+	// gcsStateSuffix is appended to the workspace name to form the
+	// name of the state object.
+	gcsStateSuffix = ".tfstate"
+)
+
+// GCSSource reads a state stored by Terraform's gcs backend. Emulators
+// such as fake-gcs-server can be used by setting Endpoint.
+type GCSSource struct {
+	Bucket      string
+	Prefix      string
+	Workspace   string
+	Endpoint    string
+	AccessToken string
+
+	// Credentials are the contents of, or the path to, a service account
+	// key or an authorized user file such as the one written by
+	// `gcloud auth application-default login`. They are exchanged for an
+	// access token unless AccessToken is set.
+	Credentials string
+
+	Client *http.Client
+}
+
+// gcsCredentials are the fields of a Google credentials file which are
+// used to obtain an access token.
+type gcsCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func init() {
+	RegisterStateSource("gcs", newGCSSourceFromURL)
+	RegisterStateSource("gs", newGCSSourceFromURL)
+}
+
+// newGCSSourceFromURL creates a GCSSource from a URL such as
+// gcs://bucket/terraform/state?workspace=staging. The endpoint parameter
+// is also supported. Credentials are read from the environment.
+func newGCSSourceFromURL(u *url.URL) (StateSource, error) {
+	s := newGCSSourceFromEnv()
+	s.Bucket = u.Host
+	s.Prefix = strings.TrimPrefix(u.Path, "/")
+	s.Endpoint = queryDefault(u, "endpoint", s.Endpoint)
+	s.Workspace = queryDefault(u, "workspace", s.Workspace)
+
+	return s, nil
+}
+
+// newGCSSourceFromEnv creates a GCSSource from the environment.
+// STORAGE_EMULATOR_HOST is honoured like in the Google Cloud libraries.
+// Like Terraform, GOOGLE_CREDENTIALS takes precedence over
+// GOOGLE_APPLICATION_CREDENTIALS.
+func newGCSSourceFromEnv() *GCSSource {
+	s := &GCSSource{
+		Bucket:      os.Getenv("TF_GCS_BUCKET"),
+		Prefix:      os.Getenv("TF_GCS_PREFIX"),
+		Workspace:   getWorkspace(),
+		Endpoint:    os.Getenv("STORAGE_EMULATOR_HOST"),
+		AccessToken: os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"),
+		Credentials: firstEnv("GOOGLE_CREDENTIALS", "GOOGLE_APPLICATION_CREDENTIALS"),
+	}
+
+	if s.Endpoint != "" && !strings.Contains(s.Endpoint, "://") {
+		s.Endpoint = "http://" + s.Endpoint
+	}
+
+	return s
+}
+
+// ObjectName returns the name of the state object for the configured
+// workspace: <prefix>/<workspace>.tfstate.
+func (s *GCSSource) ObjectName() string {
+	workspace := s.Workspace
+	if workspace == "" {
+		workspace = defaultWorkspace
+	}
+
+	return path.Join(s.Prefix, workspace+gcsStateSuffix)
+}
+
+// Fetch downloads the state object. If the object does not exist, no
+// bytes and no error are returned.
+func (s *GCSSource) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	meta := StateMetadata{
+		Source:    fmt.Sprintf("gs://%s/%s", s.Bucket, s.ObjectName()),
+		Workspace: s.Workspace,
+	}
+
+	if s.Bucket == "" {
+		return nil, meta, fmt.Errorf("A GCS bucket must be specified")
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGCSEndpoint
+	}
+
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", strings.TrimSuffix(endpoint, "/"),
+		url.PathEscape(s.Bucket), url.PathEscape(s.ObjectName()))
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error building GCS request: %s", err)
+	}
+	req = req.WithContext(ctx)
+
+	token := s.AccessToken
+	if token == "" && s.Credentials != "" {
+		token, err = s.fetchAccessToken(ctx)
+		if err != nil {
+			return nil, meta, err
+		}
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	b, err := fetchObject(s.Client, req, meta.Source)
+	return b, meta, err
+}
+
+// fetchAccessToken exchanges the credentials for an access token. A
+// service account signs a JWT with its key, while an authorized user
+// presents its refresh token.
+func (s *GCSSource) fetchAccessToken(ctx context.Context) (string, error) {
+	b := []byte(s.Credentials)
+	if !strings.HasPrefix(strings.TrimSpace(s.Credentials), "{") {
+		var err error
+		b, err = ioutil.ReadFile(s.Credentials)
+		if err != nil {
+			return "", fmt.Errorf("Error reading Google credentials: %s", err)
+		}
+	}
+
+	var c gcsCredentials
+	if err := json.Unmarshal(b, &c); err != nil {
+		return "", fmt.Errorf("Error reading Google credentials: %s", err)
+	}
+
+	if c.TokenURI == "" {
+		c.TokenURI = defaultGCSTokenURI
+	}
+
+	form := url.Values{}
+	switch c.Type {
+	case "service_account":
+		assertion, err := signJWT(c, time.Now())
+		if err != nil {
+			return "", err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+		form.Set("refresh_token", c.RefreshToken)
+
+	default:
+		return "", fmt.Errorf("Unsupported type of Google credentials: %s", c.Type)
+	}
+
+	req, err := http.NewRequest("POST", c.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("Error building token request: %s", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// A missing token endpoint is an error here, unlike a missing state.
+	b, err = fetchObject(s.Client, req, c.TokenURI)
+	if err == nil && b == nil {
+		err = fmt.Errorf("Error fetching access token from %s: 404 Not Found", c.TokenURI)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(b, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("Error reading access token from %s: %s", c.TokenURI, strings.TrimSpace(string(b)))
+	}
+
+	return token.AccessToken, nil
+}
+
+// signJWT returns the JWT which a service account exchanges for an access
+// token, signed with its private key.
+func signJWT(c gcsCredentials, t time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("Error reading Google credentials: invalid private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error reading Google credentials: %s", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("Error reading Google credentials: the private key is not an RSA key")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   c.ClientEmail,
+		"scope": gcsReadScope,
+		"aud":   c.TokenURI,
+		"iat":   t.Unix(),
+		"exp":   t.Add(time.Hour).Unix(),
+	})
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("Error signing JWT: %s", err)
+	}
+
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
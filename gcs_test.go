@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCSV012_fetch(t *testing.T) {
+	b, err := ioutil.ReadFile("fixtures/v012/nbering-ansible/terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("alt") != "media" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.EscapedPath() {
+		case "/storage/v1/b/bucket/o/terraform%2Fstate%2Fstaging.tfstate":
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	s := &GCSSource{
+		Bucket:      "bucket",
+		Prefix:      "terraform/state",
+		Workspace:   "staging",
+		Endpoint:    ts.URL,
+		AccessToken: "token",
+	}
+
+	actual, _, err := s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := parseState(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedStateV012, state)
+
+	s.Workspace = defaultWorkspace
+	actual, _, err = s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, actual)
+}
+
+func TestGCS_credentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte("{}"))
+			return
+		}
+
+		r.ParseForm()
+		switch r.Form.Get("grant_type") {
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+		default:
+			// The assertion is a JWT signed with the service account key.
+			parts := strings.Split(r.Form.Get("assertion"), ".")
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+			hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		w.Write([]byte(`{"access_token": "token", "expires_in": 3600}`))
+	}))
+	defer ts.Close()
+
+	serviceAccount, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "inventory@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    ts.URL + "/token",
+	})
+
+	file, err := ioutil.TempFile("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(serviceAccount)
+	file.Close()
+
+	authorizedUser, _ := json.Marshal(map[string]string{
+		"type":          "authorized_user",
+		"client_id":     "client",
+		"client_secret": "secret",
+		"refresh_token": "refresh",
+		"token_uri":     ts.URL + "/token",
+	})
+
+	for _, credentials := range []string{string(serviceAccount), file.Name(), string(authorizedUser)} {
+		s := &GCSSource{Bucket: "bucket", Endpoint: ts.URL, Credentials: credentials}
+
+		b, _, err := s.Fetch(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "{}", string(b))
+	}
+
+	s := &GCSSource{Bucket: "bucket", Endpoint: ts.URL, Credentials: `{"type": "external_account"}`}
+	_, _, err = s.Fetch(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// HTTPSource reads a state stored by Terraform's http backend, such as
+// GitLab-managed Terraform states.
+type HTTPSource struct {
+	Address  string
+	Username string
+	Password string
+	Headers  map[string]string
+
+	SkipCertVerification   bool
+	ClientCACertificatePEM string
+	ClientCertificatePEM   string
+	ClientPrivateKeyPEM    string
+}
+
+func init() {
+	RegisterStateSource("http", newHTTPSourceFromURL)
+	RegisterStateSource("https", newHTTPSourceFromURL)
+}
+
+// newHTTPSourceFromURL creates an HTTPSource for the state at u. Basic
+// authentication credentials can be part of the URL; the remaining
+// settings are read from the environment.
+func newHTTPSourceFromURL(u *url.URL) (StateSource, error) {
+	s, err := newHTTPSourceFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	address := *u
+	if address.User != nil {
+		s.Username = address.User.Username()
+		s.Password, _ = address.User.Password()
+		address.User = nil
+	}
+	s.Address = address.String()
+
+	return s, nil
+}
+
+// newHTTPSourceFromEnv creates an HTTPSource from the same environment
+// variables Terraform's http backend uses.
+func newHTTPSourceFromEnv() (*HTTPSource, error) {
+	s := &HTTPSource{
+		Address:                os.Getenv("TF_HTTP_ADDRESS"),
+		Username:               os.Getenv("TF_HTTP_USERNAME"),
+		Password:               os.Getenv("TF_HTTP_PASSWORD"),
+		Headers:                make(map[string]string),
+		ClientCACertificatePEM: os.Getenv("TF_HTTP_CLIENT_CA_CERTIFICATE_PEM"),
+		ClientCertificatePEM:   os.Getenv("TF_HTTP_CLIENT_CERTIFICATE_PEM"),
+		ClientPrivateKeyPEM:    os.Getenv("TF_HTTP_CLIENT_PRIVATE_KEY_PEM"),
+	}
+
+	if v := os.Getenv("TF_HTTP_SKIP_CERT_VERIFICATION"); v != "" {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for TF_HTTP_SKIP_CERT_VERIFICATION: %s", v)
+		}
+		s.SkipCertVerification = skip
+	}
+
+	// Headers are specified as a comma-separated list of name=value pairs.
+	if v := os.Getenv("TF_HTTP_HEADERS"); v != "" {
+		for _, h := range strings.Split(v, ",") {
+			pieces := strings.SplitN(h, "=", 2)
+			if len(pieces) != 2 {
+				return nil, fmt.Errorf("Invalid header in TF_HTTP_HEADERS: %s", h)
+			}
+			s.Headers[strings.TrimSpace(pieces[0])] = strings.TrimSpace(pieces[1])
+		}
+	}
+
+	return s, nil
+}
+
+// Client returns an HTTP client configured with the TLS settings of
+// the source.
+func (s *HTTPSource) Client() (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: s.SkipCertVerification,
+	}
+
+	if s.ClientCACertificatePEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(s.ClientCACertificatePEM)) {
+			return nil, fmt.Errorf("Unable to parse the client CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if s.ClientCertificatePEM != "" || s.ClientPrivateKeyPEM != "" {
+		cert, err := tls.X509KeyPair([]byte(s.ClientCertificatePEM), []byte(s.ClientPrivateKeyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// Fetch retrieves the state from the address of the source. If no state
+// exists, no bytes and no error are returned.
+func (s *HTTPSource) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	meta := StateMetadata{Source: s.Address}
+
+	if s.Address == "" {
+		return nil, meta, fmt.Errorf("An HTTP state address must be specified")
+	}
+
+	client, err := s.Client()
+	if err != nil {
+		return nil, meta, err
+	}
+
+	req, err := http.NewRequest("GET", s.Address, nil)
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error building HTTP request: %s", err)
+	}
+	req = req.WithContext(ctx)
+
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if s.Username != "" || s.Password != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error fetching state from %s: %s", s.Address, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error reading state from %s: %s", s.Address, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, meta, nil
+	default:
+		return nil, meta, newStatusError(resp, b, "Error fetching state from %s", s.Address)
+	}
+
+	// Verify the checksum of the state if the server provided one.
+	if v := resp.Header.Get("Content-MD5"); v != "" {
+		expected, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, meta, fmt.Errorf("Invalid Content-MD5 returned by %s: %s", s.Address, v)
+		}
+
+		actual := md5.Sum(b)
+		if string(expected) != string(actual[:]) {
+			return nil, meta, fmt.Errorf("The state returned by %s does not match its Content-MD5", s.Address)
+		}
+	}
+
+	return b, meta, nil
+}
+
+// fetchObject performs req and returns the body of the response. It is
+// used by the object storage sources, which all treat a missing object as
+// a missing state: no bytes and no error are returned.
+func fetchObject(client *http.Client, req *http.Request, name string) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching state from %s: %s", name, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading state from %s: %s", name, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp, b, "Error fetching state from %s", name)
+	}
+
+	return b, nil
+}
+
+// statusError is the error of an HTTP request which returned an unexpected
+// status. Whether it is transient is decided by its status code.
+type statusError struct {
+	StatusCode int
+	message    string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// newStatusError returns a statusError for the response with the given
+// body, whose message is the formatted prefix followed by the status and
+// the body.
+func newStatusError(resp *http.Response, body []byte, format string, args ...interface{}) error {
+	return &statusError{
+		StatusCode: resp.StatusCode,
+		message: fmt.Sprintf("%s: %s: %s", fmt.Sprintf(format, args...),
+			resp.Status, strings.TrimSpace(string(body))),
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPV012_fetch(t *testing.T) {
+	b, err := ioutil.ReadFile("fixtures/v012/nbering-ansible/terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "gitlab-ci-token" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Header.Get("X-Custom") != "foo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/state/production":
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	caPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: ts.Certificate().Raw,
+	})
+
+	s := &HTTPSource{
+		Address:                ts.URL + "/state/production",
+		Username:               "gitlab-ci-token",
+		Password:               "secret",
+		Headers:                map[string]string{"X-Custom": "foo"},
+		ClientCACertificatePEM: string(caPEM),
+	}
+
+	actual, _, err := s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := parseState(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedStateV012, state)
+
+	s.Address = ts.URL + "/state/missing"
+	actual, _, err = s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, actual)
+
+	s.Password = "wrong"
+	_, _, err = s.Fetch(context.Background())
+	assert.Error(t, err)
+}
+
+func TestHTTP_untrusted(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	s := &HTTPSource{
+		Address: ts.URL,
+	}
+
+	_, _, err := s.Fetch(context.Background())
+	assert.Error(t, err)
+
+	s.SkipCertVerification = true
+	actual, _, err := s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []byte("{}"), actual)
+}
@@ -1,61 +1,87 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var (
-	list    = flag.Bool("list", false, "list mode")
-	command = Terraform
+	list      = flag.Bool("list", false, "list mode")
+	stateFile = flag.String("state-file", "", "path to a Terraform state file")
+	stateArg  = flag.String("state", "", "path to a Terraform state file, or - to read from stdin")
+	stdin     = flag.Bool("stdin", false, "read the Terraform state from stdin")
+	source    = flag.String("source", "", "URL of the state source, such as s3://bucket/key")
+	command   = Terraform
+
+	retryPolicy  = defaultRetryPolicy
+	moduleFilter ModuleFilter
+
+	workspaces      = flag.String("workspaces", "", "comma-separated list of workspaces to merge, or * for all workspaces")
+	workspaceGroups = flag.Bool("workspace-groups", false, "add the hosts of each workspace to a workspace_<name> group")
+	recursive       = flag.Bool("recursive", false, "read the state of every Terragrunt unit below TF_STATE")
+	unitGroups      = flag.Bool("unit-groups", false, "add the hosts of each Terragrunt unit to a unit_<path> group")
+	commandTemplate = flag.String("command", "", "command line which pulls the state, such as 'aws-vault exec prod -- terraform state pull'")
+	debug           = flag.Bool("debug", false, "print debug messages to stderr")
+	retries         = flag.Int("retries", -1, "number of times a state is read again after a transient error")
+	timeout         = flag.Duration("timeout", 0, "maximum time to read the states, such as 90s")
+	cacheTTL        = flag.Duration("cache-ttl", 0, "reuse the inventory built within this time, such as 5m")
+	cacheEncrypt    = flag.Bool("cache-encrypt", false, "require the cached inventory to be encrypted")
+	refresh         = flag.Bool("refresh", false, "rebuild the cached inventory")
+	parallelism     = flag.Int("parallelism", 0, "maximum number of states read at the same time")
+
+	modules            = flag.String("module", "", "comma-separated list of the modules whose hosts and groups are included, such as module.web or module.web_*")
+	excludeModules     = flag.String("exclude-module", "", "comma-separated list of the modules whose hosts and groups are excluded")
+	searchChildModules = flag.Bool("search-child-modules", false, "include or exclude the child modules of the given modules as well")
+	includeTainted     = flag.Bool("include-tainted", false, "include tainted hosts and groups, with terraform_tainted set on the hosts")
 )
 
 const (
 	Terraform  = "terraform"
 	Terragrunt = "terragrunt"
+	OpenTofu   = "tofu"
+
+	defaultWorkspace = "default"
 )
 
 func main() {
 	flag.Parse()
 
-	v := os.Getenv("TF_TERRAGRUNT")
-	if v != "" {
-		command = Terragrunt
-	}
+	command = getCommand()
 
-	if *list {
-		file := getStatePath()
-		path, err := filepath.Abs(file)
-		if err != nil {
-			errAndExit(fmt.Errorf("Error determining directory: %s", err))
-		}
-
-		f, err := os.Stat(path)
-		if err != nil {
-			errAndExit(fmt.Errorf("Error determining directory: %s", err))
-		}
+	p, err := getRetryPolicy()
+	if err != nil {
+		errAndExit(err)
+	}
+	retryPolicy = p
 
-		if !f.IsDir() {
-			errAndExit(fmt.Errorf("Invalid directory: %s", file))
-		}
+	f, err := getModuleFilter()
+	if err != nil {
+		errAndExit(err)
+	}
+	moduleFilter = f
 
-		s, err := getState(path)
+	if *list {
+		ctx, cancel, err := newContext()
 		if err != nil {
 			errAndExit(err)
 		}
+		defer cancel()
 
-		if s == nil {
+		j, err := listInventory(ctx)
+		if err == errNoState {
 			fmt.Println("No state was found")
 			os.Exit(1)
 		}
 
-		j, err := ToJSON(s)
 		if err != nil {
 			errAndExit(err)
 		}
@@ -64,77 +90,440 @@ func main() {
 	}
 }
 
-func getStatePath() string {
-	var v string
+// errNoState is returned by buildInventory if no state was found.
+var errNoState = errors.New("No state was found")
 
-	v = os.Getenv("TF_STATE")
-	if v != "" {
-		return v
+// listInventory returns the inventory as JSON. If the cache is enabled, a
+// cached inventory is returned unless it has expired or a refresh was
+// requested.
+func listInventory(ctx context.Context) (string, error) {
+	cache, err := getCache()
+	if err != nil {
+		return "", err
 	}
 
-	return "."
+	if cache == nil || getStateFile() == "-" {
+		j, _, err := buildInventory(ctx)
+		return j, err
+	}
+
+	key, err := inventoryCacheKey()
+	if err != nil {
+		return "", err
+	}
+
+	// Other processes building the same inventory are waited for, so
+	// that their result can be used.
+	unlock, err := cache.Lock(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if e, fresh := cache.Get(key); e != nil && !getRefresh() {
+		if fresh {
+			debugf("Using cached inventory %s", cache.path(key))
+			return e.Inventory, nil
+		}
+
+		// An expired inventory is still up to date if the revisions of
+		// all states are unchanged.
+		if revisionsUnchanged(ctx, e.Revisions) {
+			debugf("Using cached inventory %s, the states are unchanged", cache.path(key))
+			return e.Inventory, cache.Put(key, e.Inventory, e.Revisions)
+		}
+	}
+
+	j, revisions, err := buildInventory(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cache.Put(key, j, revisions); err != nil {
+		return "", err
+	}
+
+	return j, nil
 }
 
-func getState(path string) (State, error) {
-	var out bytes.Buffer
-	var state State
-	terraformVersion := "0.12"
+// buildInventory reads the states and returns the inventory as JSON, and
+// the revisions of the states.
+func buildInventory(ctx context.Context) (string, []StateRevision, error) {
+	s, err := loadState(ctx)
+	if err != nil {
+		return "", nil, err
+	}
 
-	cmd := exec.Command(command, "state", "pull")
-	cmd.Dir = path
-	cmd.Stdout = &out
+	if s == nil {
+		return "", nil, errNoState
+	}
 
-	err := cmd.Run()
+	j, err := ToJSON(s)
+	return j, s.GetRevisions(), err
+}
+
+// newContext returns the context of the inventory. It is cancelled when
+// the inventory receives SIGINT or SIGTERM, such as when Ansible is
+// interrupted, or when the timeout given with --timeout or
+// TF_INVENTORY_TIMEOUT expires.
+func newContext() (context.Context, context.CancelFunc, error) {
+	timeout, err := getTimeout()
 	if err != nil {
-		return nil, fmt.Errorf("Error running `%s state pull` in directory %s, %s\n", command, path, err)
+		return nil, nil, err
+	}
+
+	ctx := context.Background()
+	cancelTimeout := func() {}
+	if timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+		cancelTimeout()
+	}, nil
+}
+
+// getTimeout returns the timeout of the inventory, given with --timeout
+// or TF_INVENTORY_TIMEOUT as a duration such as 90s or as seconds.
+func getTimeout() (time.Duration, error) {
+	if *timeout > 0 {
+		return *timeout, nil
+	}
+
+	v := os.Getenv("TF_INVENTORY_TIMEOUT")
+	if v == "" {
+		return 0, nil
 	}
 
-	b, err := ioutil.ReadAll(&out)
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid TF_INVENTORY_TIMEOUT: %s", v)
+	}
+
+	return d, nil
+}
+
+// loadState obtains the state from the configured StateSources. The
+// states of several sources and workspaces are read concurrently and
+// merged into one.
+func loadState(ctx context.Context) (State, error) {
+	limit, err := getParallelism()
 	if err != nil {
-		return nil, fmt.Errorf("Error reading output of `%s state pull`: %s\n", command, err)
+		return nil, err
 	}
 
-	// If there was no output, return nil and no error
-	if string(b) == "" {
-		return nil, nil
+	requests, err := resolveStateRequests(ctx, limit)
+	if err != nil {
+		return nil, err
 	}
 
-	if string(b[0]) == "o" && string(b[1]) == ":" {
-		b = append(b[:0], b[2:]...)
+	states, err := getStates(ctx, requests, limit)
+	if err != nil {
+		return nil, err
 	}
 
-	var tmpState interface{}
-	err = json.Unmarshal(b, &tmpState)
+	return mergeStates(states), nil
+}
+
+// resolveStateRequests returns a stateRequest for each state to read. If
+// several workspaces are requested, they are listed for each source.
+func resolveStateRequests(ctx context.Context, limit int) ([]stateRequest, error) {
+	sources, err := getStateRequests()
 	if err != nil {
-		return nil, fmt.Errorf("Error unmarshaling state: %s\n", err)
+		return nil, err
 	}
 
-	if v, ok := tmpState.(map[string]interface{}); ok {
-		if v, ok := v["version"].(float64); ok {
-			if v == 3 {
-				terraformVersion = "0.11"
-			}
+	return expandWorkspaces(ctx, sources, limit)
+}
+
+// expandWorkspaces replaces each of the sources with a stateRequest for
+// each of the requested workspaces. Without several workspaces, the
+// sources are returned as is.
+func expandWorkspaces(ctx context.Context, sources []stateRequest, limit int) ([]stateRequest, error) {
+	names := getWorkspaces()
+	grouped := getWorkspaceGroups()
+
+	// Listing the workspaces may run a command for each source as well.
+	perSource := make([][]stateRequest, len(sources))
+	err := forEach(ctx, len(sources), limit, func(ctx context.Context, i int) error {
+		if len(names) == 0 {
+			perSource[i] = []stateRequest{sources[i]}
+			return nil
 		}
+
+		requests, err := workspaceRequests(ctx, sources[i].Source, names, grouped)
+		for j := range requests {
+			requests[j].Groups = append(requests[j].Groups, sources[i].Groups...)
+		}
+
+		perSource[i] = requests
+		return err
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	switch terraformVersion {
-	case "0.11":
-		var s StateV011
-		err = json.Unmarshal(b, &s)
-		if err != nil {
-			return nil, fmt.Errorf("Error unmarshaling state: %s\n", err)
+	var requests []stateRequest
+	for _, r := range perSource {
+		requests = append(requests, r...)
+	}
+
+	return requests, nil
+}
+
+// getStateRequests determines the StateSources from the flags and the
+// environment. A state file or stdin takes precedence, followed by a
+// source URL given with --source or TF_STATE, followed by the remote
+// backends configured through environment variables. Otherwise
+// `terraform state pull` is run in each of the TF_STATE directories, or
+// in each Terragrunt unit below them.
+func getStateRequests() ([]stateRequest, error) {
+	if file := getStateFile(); file != "" {
+		if file == "-" {
+			return []stateRequest{{Source: &ReaderSource{Name: "stdin", Reader: os.Stdin}}}, nil
 		}
-		state = s
-	default:
-		var s StateV012
-		err = json.Unmarshal(b, &s)
+
+		return []stateRequest{{Source: &FileSource{Path: file}}}, nil
+	}
+
+	if source, err := getRemoteStateSource(); source != nil || err != nil {
+		return []stateRequest{{Source: source}}, err
+	}
+
+	dirs, err := getStateDirs(getStatePath())
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := getCommandTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	base := CommandSource{Command: command, Template: template}
+
+	recursive := getTerragruntRecursive()
+	if recursive && !isTerragrunt(command) {
+		return nil, fmt.Errorf("The recursive mode requires TF_TERRAGRUNT to be set")
+	}
+
+	var requests []stateRequest
+	for _, dir := range dirs {
+		if !recursive {
+			source := base
+			source.Dir = dir
+			requests = append(requests, stateRequest{Source: &source})
+			continue
+		}
+
+		units, err := terragruntUnitRequests(base, dir, getTerragruntUnitGroups())
 		if err != nil {
-			return nil, fmt.Errorf("Error unmarshaling state: %s\n", err)
+			return nil, err
+		}
+
+		requests = append(requests, units...)
+	}
+
+	return requests, nil
+}
+
+// getRemoteStateSource returns the StateSource given as a URL, or the
+// remote backend configured through environment variables. If neither
+// is set, nil is returned.
+func getRemoteStateSource() (StateSource, error) {
+	if v := getSourceURL(); v != "" {
+		return NewStateSource(v)
+	}
+
+	switch {
+	case os.Getenv("TF_S3_BUCKET") != "":
+		return newS3SourceFromEnv(), nil
+	case os.Getenv("TF_HTTP_ADDRESS") != "":
+		return newHTTPSourceFromEnv()
+	case os.Getenv("TF_CLOUD_ORGANIZATION") != "":
+		return newTFCSourceFromEnv(), nil
+	case os.Getenv("TF_PG_CONN_STR") != "":
+		return newPGSourceFromEnv(), nil
+	case os.Getenv("TF_CONSUL_PATH") != "":
+		return newConsulSourceFromEnv(), nil
+	case os.Getenv("TF_AZURERM_STORAGE_ACCOUNT_NAME") != "":
+		return newAzureSourceFromEnv(), nil
+	case os.Getenv("TF_GCS_BUCKET") != "":
+		return newGCSSourceFromEnv(), nil
+	}
+
+	return nil, nil
+}
+
+// getStateDirs expands the TF_STATE value into absolute directories.
+// Several directories can be separated like in PATH (":" on Unix) and each
+// of them may be a glob such as infra/*/. Files matched by a glob are
+// ignored.
+func getStateDirs(v string) ([]string, error) {
+	var dirs []string
+	seen := make(map[string]bool)
+
+	for _, pattern := range filepath.SplitList(v) {
+		if pattern == "" {
+			continue
+		}
+
+		pattern = filepath.Clean(pattern)
+		isGlob := strings.ContainsAny(pattern, "*?[")
+
+		matches := []string{pattern}
+		if isGlob {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid directory pattern %s: %s", pattern, err)
+			}
+
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("No directories match %s", pattern)
+			}
+		}
+
+		for _, file := range matches {
+			path, err := filepath.Abs(file)
+			if err != nil {
+				return nil, fmt.Errorf("Error determining directory: %s", err)
+			}
+
+			f, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("Error determining directory: %s", err)
+			}
+
+			if !f.IsDir() {
+				if isGlob {
+					continue
+				}
+				return nil, fmt.Errorf("Invalid directory: %s", file)
+			}
+
+			if !seen[path] {
+				seen[path] = true
+				dirs = append(dirs, path)
+			}
+		}
+	}
+
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("No directories match %s", v)
+	}
+
+	return dirs, nil
+}
+
+// getStateFromSource fetches and parses the state of a StateSource.
+func getStateFromSource(ctx context.Context, source StateSource) (State, error) {
+	var (
+		b    []byte
+		meta StateMetadata
+	)
+
+	err := retryPolicy.Do(ctx, func() error {
+		var err error
+		b, meta, err = source.Fetch(ctx)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	state, skipped, err := extractState(b)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading state from %s: %s", meta.Source, err)
+	}
+
+	for _, line := range skipped {
+		debugf("Skipped output of %s: %s", meta.Source, line)
+	}
+
+	state = filterState(state, moduleFilter)
+	return filterInstances(state, getIncludeTainted()), nil
+}
+
+func getStatePath() string {
+	var v string
+
+	v = os.Getenv("TF_STATE")
+	if v != "" && !isStateSourceURL(v) {
+		return v
+	}
+
+	return "."
+}
+
+// getSourceURL returns the URL of the StateSource given with --source
+// or TF_STATE.
+func getSourceURL() string {
+	if *source != "" {
+		return *source
+	}
+
+	if v := os.Getenv("TF_STATE"); isStateSourceURL(v) {
+		return v
+	}
+
+	return ""
+}
+
+// firstEnv returns the value of the first non-empty environment variable.
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
 		}
-		state = s
 	}
 
-	return state, nil
+	return ""
+}
+
+func getStateFile() string {
+	if *stdin {
+		return "-"
+	}
+
+	if *stateArg != "" {
+		return *stateArg
+	}
+
+	if *stateFile != "" {
+		return *stateFile
+	}
+
+	return os.Getenv("TF_STATE_FILE")
+}
+
+// debugf prints a message to stderr if debug mode is enabled with --debug
+// or TF_INVENTORY_DEBUG.
+func debugf(format string, args ...interface{}) {
+	if *debug || os.Getenv("TF_INVENTORY_DEBUG") != "" {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
 }
 
 func errAndExit(err error) {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStateDirs(t *testing.T) {
+	abs := func(p string) string {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	dirs, err := getStateDirs("fixtures/v012/*/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{
+		abs("fixtures/v012/ansible-ansible"),
+		abs("fixtures/v012/nbering-ansible"),
+	}, dirs)
+
+	list := "fixtures/v011" + string(os.PathListSeparator) + "fixtures/v01*"
+	dirs, err = getStateDirs(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{abs("fixtures/v011"), abs("fixtures/v012")}, dirs)
+
+	dirs, err = getStateDirs("fixtures/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotContains(t, dirs, abs("fixtures/main.tf"))
+
+	_, err = getStateDirs("fixtures/main.tf")
+	assert.Error(t, err)
+
+	_, err = getStateDirs("fixtures/nothing-*")
+	assert.Error(t, err)
+}
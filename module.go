@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// rootModule is the name of the root module in module patterns.
+const rootModule = "root"
+
+// ModuleFilter selects the resources of a state by the address of their
+// module, such as module.web or module.web.module.db. Patterns are
+// compared one module at a time and may contain shell globs, such as
+// module.web_*, in the name of a module. The instance key of a module,
+// such as [0] in module.web[0], is matched literally. The root module is
+// matched by "root".
+type ModuleFilter struct {
+	// Include are the patterns of the modules whose resources are kept.
+	// All modules are kept if there are none.
+	Include []string
+
+	// Exclude are the patterns of the modules whose resources are left
+	// out, even if they are included.
+	Exclude []string
+
+	// Recursive makes a pattern match the child modules of the modules
+	// it matches as well.
+	Recursive bool
+}
+
+// getModuleFilter returns the module filter given with --module,
+// --exclude-module and --search-child-modules, or with
+// TF_INVENTORY_MODULE, TF_INVENTORY_EXCLUDE_MODULE and
+// TF_INVENTORY_SEARCH_CHILD_MODULES.
+func getModuleFilter() (ModuleFilter, error) {
+	var f ModuleFilter
+
+	include := *modules
+	if include == "" {
+		include = os.Getenv("TF_INVENTORY_MODULE")
+	}
+
+	exclude := *excludeModules
+	if exclude == "" {
+		exclude = os.Getenv("TF_INVENTORY_EXCLUDE_MODULE")
+	}
+
+	f.Recursive = *searchChildModules
+	if v := os.Getenv("TF_INVENTORY_SEARCH_CHILD_MODULES"); v != "" && !f.Recursive {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, fmt.Errorf("Invalid TF_INVENTORY_SEARCH_CHILD_MODULES: %s", v)
+		}
+		f.Recursive = b
+	}
+
+	var err error
+	if f.Include, err = modulePatterns(include); err != nil {
+		return f, err
+	}
+
+	if f.Exclude, err = modulePatterns(exclude); err != nil {
+		return f, err
+	}
+
+	return f, nil
+}
+
+// modulePatterns splits a comma-separated list of module patterns and
+// checks their syntax.
+func modulePatterns(v string) ([]string, error) {
+	var patterns []string
+
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+
+		for _, segment := range moduleSegments(p) {
+			if strings.Contains(segment, "[") && !strings.HasSuffix(segment, "]") {
+				return nil, fmt.Errorf("Invalid module pattern %s: unterminated instance key", p)
+			}
+
+			if _, err := path.Match(segmentPattern(segment), ""); err != nil {
+				return nil, fmt.Errorf("Invalid module pattern %s: %s", p, err)
+			}
+		}
+
+		patterns = append(patterns, p)
+	}
+
+	return patterns, nil
+}
+
+// IsEmpty reports whether the filter keeps all resources.
+func (f ModuleFilter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Match reports whether the resources of a module are kept. The address
+// of the root module is empty.
+func (f ModuleFilter) Match(module string) bool {
+	if len(f.Include) > 0 && !f.matchAny(f.Include, module) {
+		return false
+	}
+
+	return !f.matchAny(f.Exclude, module)
+}
+
+func (f ModuleFilter) matchAny(patterns []string, module string) bool {
+	segments := moduleSegments(module)
+
+	for _, p := range patterns {
+		if f.match(moduleSegments(p), segments) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f ModuleFilter) match(pattern, segments []string) bool {
+	if len(segments) < len(pattern) || (!f.Recursive && len(segments) != len(pattern)) {
+		return false
+	}
+
+	for i, p := range pattern {
+		if ok, _ := path.Match(segmentPattern(p), segments[i]); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// segmentPattern returns the pattern of a module for path.Match, where the
+// instance key, such as [0] or ["a"], is escaped so that it is not read as
+// a character class.
+func segmentPattern(segment string) string {
+	i := strings.Index(segment, "[")
+	if i < 0 {
+		return segment
+	}
+
+	var b strings.Builder
+	b.WriteString(segment[:i])
+	for _, c := range segment[i:] {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// moduleSegments splits a module address, such as module.web.module.db,
+// into the addresses of each module, such as module.web and module.db.
+// The "module." prefix may be left out of a pattern. The root module has
+// no segments.
+func moduleSegments(address string) []string {
+	if address == "" || address == rootModule {
+		return nil
+	}
+
+	address = strings.TrimPrefix(address, "module.")
+
+	var segments []string
+	for _, s := range strings.Split(address, ".module.") {
+		segments = append(segments, "module."+s)
+	}
+
+	return segments
+}
+
+// moduleAddress returns the address of a module from its path in a state
+// written by Terraform 0.11 and prior, such as [root web db].
+func moduleAddress(path []string) string {
+	var segments []string
+
+	for i, name := range path {
+		if i == 0 && name == rootModule {
+			continue
+		}
+		segments = append(segments, "module."+name)
+	}
+
+	return strings.Join(segments, ".")
+}
+
+// filterState returns the state with only the resources of the modules
+// selected by the filter.
+func filterState(state State, f ModuleFilter) State {
+	if f.IsEmpty() {
+		return state
+	}
+
+	switch s := state.(type) {
+	case StateV012:
+		var resources []ResourceV012
+		for _, r := range s.Resources {
+			if f.Match(r.Module) {
+				resources = append(resources, r)
+			}
+		}
+		s.Resources = resources
+		return s
+
+	case StateV011:
+		var modules []ModuleV011
+		for _, m := range s.Modules {
+			if f.Match(moduleAddress(m.Path)) {
+				modules = append(modules, m)
+			}
+		}
+		s.Modules = modules
+		return s
+	}
+
+	return state
+}
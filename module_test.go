@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleSegments(t *testing.T) {
+	assert.Nil(t, moduleSegments(""))
+	assert.Nil(t, moduleSegments("root"))
+	assert.Equal(t, []string{"module.web"}, moduleSegments("module.web"))
+	assert.Equal(t, []string{"module.web"}, moduleSegments("web"))
+	assert.Equal(t, []string{"module.web[0]", "module.db"}, moduleSegments("module.web[0].module.db"))
+
+	assert.Equal(t, "", moduleAddress([]string{"root"}))
+	assert.Equal(t, "module.web.module.db", moduleAddress([]string{"root", "web", "db"}))
+}
+
+func TestModuleFilter_Match(t *testing.T) {
+	tests := []struct {
+		filter  ModuleFilter
+		matches []string
+		misses  []string
+	}{
+		{
+			filter:  ModuleFilter{},
+			matches: []string{"", "module.web", "module.web.module.db"},
+		},
+		{
+			filter:  ModuleFilter{Include: []string{"module.web"}},
+			matches: []string{"module.web"},
+			misses:  []string{"", "module.web2", "module.web.module.db"},
+		},
+		{
+			filter:  ModuleFilter{Include: []string{"module.web"}, Recursive: true},
+			matches: []string{"module.web", "module.web.module.db"},
+			misses:  []string{"", "module.db.module.web"},
+		},
+		{
+			filter:  ModuleFilter{Include: []string{"module.web_*", "root"}},
+			matches: []string{"", "module.web_a", "module.web_b[0]"},
+			misses:  []string{"module.db", "module.web_a.module.db"},
+		},
+		{
+			filter:  ModuleFilter{Include: []string{"module.web[0]", `module.db["a"]`}},
+			matches: []string{"module.web[0]", `module.db["a"]`},
+			misses:  []string{"module.web0", "module.web[1]", "module.web", `module.db["b"]`, "module.dba"},
+		},
+		{
+			filter:  ModuleFilter{Include: []string{`module.web_*["*"]`}},
+			matches: []string{`module.web_a["*"]`},
+			misses:  []string{`module.web_a["b"]`},
+		},
+		{
+			filter:  ModuleFilter{Exclude: []string{"module.db"}, Recursive: true},
+			matches: []string{"", "module.web", "module.web.module.db"},
+			misses:  []string{"module.db", "module.db.module.replica"},
+		},
+		{
+			filter:  ModuleFilter{Include: []string{"root"}, Recursive: true, Exclude: []string{"*.module.*"}},
+			matches: []string{"", "module.web"},
+			misses:  []string{"module.web.module.db"},
+		},
+	}
+
+	for _, test := range tests {
+		for _, m := range test.matches {
+			assert.True(t, test.filter.Match(m), "%+v should match %q", test.filter, m)
+		}
+		for _, m := range test.misses {
+			assert.False(t, test.filter.Match(m), "%+v should not match %q", test.filter, m)
+		}
+	}
+}
+
+func TestGetModuleFilter(t *testing.T) {
+	defer setenv("TF_INVENTORY_MODULE", "module.web, module.db_*")()
+	defer setenv("TF_INVENTORY_EXCLUDE_MODULE", "module.db_test")()
+	defer setenv("TF_INVENTORY_SEARCH_CHILD_MODULES", "true")()
+
+	f, err := getModuleFilter()
+	assert.NoError(t, err)
+	assert.Equal(t, ModuleFilter{
+		Include:   []string{"module.web", "module.db_*"},
+		Exclude:   []string{"module.db_test"},
+		Recursive: true,
+	}, f)
+
+	defer setenv("TF_INVENTORY_MODULE", "module.[web")()
+	_, err = getModuleFilter()
+	assert.Error(t, err)
+}
+
+// testFilterState checks the hosts of a fixture state filtered by module.
+func testFilterState(t *testing.T, state State) {
+	filtered := filterState(state, ModuleFilter{Include: []string{"module.more_hosts"}})
+	hosts, err := filtered.GetHosts()
+	assert.NoError(t, err)
+	assert.Contains(t, hosts, "host_5")
+	assert.NotContains(t, hosts, "host_1")
+
+	vars, err := filtered.GetVarsForHost("host_5")
+	assert.NoError(t, err)
+	assert.Equal(t, "module.more_hosts", vars["terraform_module"])
+
+	filtered = filterState(state, ModuleFilter{Exclude: []string{"more_hosts"}})
+	hosts, err = filtered.GetHosts()
+	assert.NoError(t, err)
+	assert.NotContains(t, hosts, "host_5")
+	assert.Contains(t, hosts, "host_1")
+
+	vars, err = filtered.GetVarsForHost("host_1")
+	assert.NoError(t, err)
+	assert.NotContains(t, vars, "terraform_module")
+}
+
+func TestFilterStateV011(t *testing.T) {
+	state, err := getStateFromSource(context.Background(), &FileSource{Path: "fixtures/v011/terraform.tfstate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFilterState(t, state)
+}
+
+func TestFilterStateV012(t *testing.T) {
+	state, err := getStateFromSource(context.Background(), &FileSource{Path: "fixtures/v012/nbering-ansible/terraform.tfstate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFilterState(t, state)
+}
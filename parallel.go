@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const defaultParallelism = 8
+
+// stateRequest is a StateSource to read. The hosts of the state are added
+// to each of the Groups.
+type stateRequest struct {
+	Source    StateSource
+	Workspace string
+	Groups    []string
+}
+
+// getParallelism returns the maximum number of states read at the same
+// time, given with --parallelism or TF_INVENTORY_PARALLELISM.
+func getParallelism() (int, error) {
+	if *parallelism > 0 {
+		return *parallelism, nil
+	}
+
+	v := os.Getenv("TF_INVENTORY_PARALLELISM")
+	if v == "" {
+		return defaultParallelism, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("Invalid TF_INVENTORY_PARALLELISM: %s", v)
+	}
+
+	return n, nil
+}
+
+// getStates reads the states of all requests concurrently. The states are
+// returned in the order of the requests, so that merging them does not
+// depend on which state was read first.
+func getStates(ctx context.Context, requests []stateRequest, limit int) ([]State, error) {
+	states := make([]State, len(requests))
+
+	err := forEach(ctx, len(requests), limit, func(ctx context.Context, i int) error {
+		r := requests[i]
+
+		s, err := getStateFromSource(ctx, r.Source)
+		if err != nil {
+			if r.Workspace != "" {
+				return fmt.Errorf("Error reading state of workspace %s: %s", r.Workspace, err)
+			}
+			return err
+		}
+
+		for _, group := range r.Groups {
+			if s != nil {
+				s = StateGrouped{State: s, Group: group}
+			}
+		}
+
+		states[i] = s
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+// forEach calls fn for the indexes 0 to n-1, running at most limit calls
+// at the same time. Once a call fails, the context of the remaining calls
+// is cancelled and the error of the failed call is returned.
+func forEach(ctx context.Context, n, limit int, fn func(ctx context.Context, i int) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	sem := make(chan struct{}, limit)
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		sem <- struct{}{}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(ctx, i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if firstErr == nil {
+		return ctx.Err()
+	}
+
+	return firstErr
+}
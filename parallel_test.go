@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// delayedSource returns the state of a FileSource after a delay.
+type delayedSource struct {
+	FileSource
+	Delay time.Duration
+}
+
+func (s *delayedSource) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	select {
+	case <-time.After(s.Delay):
+	case <-ctx.Done():
+		return nil, StateMetadata{}, ctx.Err()
+	}
+
+	return s.FileSource.Fetch(ctx)
+}
+
+func TestForEach_limit(t *testing.T) {
+	var running, max int32
+
+	err := forEach(context.Background(), 20, 3, func(ctx context.Context, i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, max <= 3, "at most 3 calls should run at the same time, got %d", max)
+}
+
+func TestForEach_error(t *testing.T) {
+	err := forEach(context.Background(), 10, 4, func(ctx context.Context, i int) error {
+		if i == 2 {
+			return fmt.Errorf("failed %d", i)
+		}
+
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	assert.EqualError(t, err, "failed 2")
+}
+
+func TestGetStatesV012_order(t *testing.T) {
+	// The first state is read last, but still comes first.
+	requests := []stateRequest{
+		{Source: &delayedSource{
+			FileSource: FileSource{Path: "fixtures/v012/nbering-ansible/terraform.tfstate"},
+			Delay:      20 * time.Millisecond,
+		}},
+		{Source: &FileSource{Path: "fixtures/v012/ansible-ansible/terraform.tfstate"}},
+	}
+
+	states, err := getStates(context.Background(), requests, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedStateV012, states[0])
+	assert.NotEqual(t, expectedStateV012, states[1])
+}
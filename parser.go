@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Interface StateDecoder decodes the fields of a state of a particular
+// version. The state is decoded in a single pass, one top-level field at a
+// time, so that only the resources needed for the inventory are kept.
+type StateDecoder interface {
+	// DecodeField decodes the value of a top-level field. The values of
+	// unknown fields must be skipped with skipValue.
+	DecodeField(dec *json.Decoder, key string) error
+
+	// State returns the decoded state.
+	State() State
+}
+
+// StateParser returns a new StateDecoder for a state version.
+type StateParser func() StateDecoder
+
+var (
+	stateParsersMu sync.RWMutex
+	stateParsers   = make(map[int]StateParser)
+)
+
+// errNotState is returned by decodeState for a JSON object without a
+// version, such as a structured log line.
+var errNotState = errors.New("The JSON object is not a state")
+
+// stateError is an error in a state, as opposed to an error in JSON which
+// turns out not to be a state.
+type stateError struct {
+	error
+}
+
+// RegisterStateParser registers the parser of a state version, the
+// version field of the state.
+func RegisterStateParser(version int, parser StateParser) {
+	stateParsersMu.Lock()
+	defer stateParsersMu.Unlock()
+
+	if _, ok := stateParsers[version]; ok {
+		panic(fmt.Sprintf("state parser for version %d registered twice", version))
+	}
+
+	stateParsers[version] = parser
+}
+
+// rawField is a top-level field which was read before the version of the
+// state was known.
+type rawField struct {
+	key   string
+	value json.RawMessage
+}
+
+// decodeState decodes the state at the beginning of b and returns it along
+// with its length. The version of the state selects the StateDecoder of
+// the remaining fields. Terraform writes the version first, otherwise the
+// fields before it are decoded once it is known.
+//
+// Errors which occur before the version is known, such as syntax errors,
+// are returned as is. A truncated state returns io.ErrUnexpectedEOF.
+func decodeState(b []byte) (State, int, error) {
+	r := bytes.NewReader(b)
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		version          *int
+		terraformVersion string
+		decoder          StateDecoder
+		pending          []rawField
+	)
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, 0, eofError(err)
+		}
+
+		key, _ := t.(string)
+
+		switch {
+		case key == "version":
+			if err := dec.Decode(&version); err != nil {
+				return nil, 0, eofError(err)
+			}
+
+			// A null version is no version, like in a log line.
+			if version == nil {
+				continue
+			}
+
+			stateParsersMu.RLock()
+			parser, ok := stateParsers[*version]
+			stateParsersMu.RUnlock()
+
+			if ok {
+				decoder = parser()
+			}
+
+		case key == "terraform_version":
+			if err := dec.Decode(&terraformVersion); err != nil {
+				return nil, 0, eofError(err)
+			}
+
+		case decoder != nil:
+			if err := decoder.DecodeField(dec, key); err != nil {
+				return nil, 0, decodeError(err)
+			}
+
+		default:
+			var v json.RawMessage
+			if err := dec.Decode(&v); err != nil {
+				return nil, 0, eofError(err)
+			}
+
+			// The fields of a state of an unsupported version are
+			// not needed.
+			if version == nil {
+				pending = append(pending, rawField{key, v})
+			}
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, 0, err
+	}
+
+	n := len(b) - r.Len() - dec.Buffered().(*bytes.Reader).Len()
+
+	if version == nil {
+		return nil, n, errNotState
+	}
+
+	if decoder == nil {
+		if terraformVersion == "" {
+			terraformVersion = "unknown"
+		}
+
+		return nil, n, stateError{fmt.Errorf("Error parsing state: unsupported state version %d (terraform_version %s)",
+			*version, terraformVersion)}
+	}
+
+	for _, f := range pending {
+		if err := decoder.DecodeField(json.NewDecoder(bytes.NewReader(f.value)), f.key); err != nil {
+			return nil, n, decodeError(err)
+		}
+	}
+
+	return decoder.State(), n, nil
+}
+
+// decodeArray calls fn for each element of the JSON array at the current
+// position of dec.
+func decodeArray(dec *json.Decoder, fn func() error) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+// decodeObject calls fn with the key of each field of the JSON object at
+// the current position of dec. fn must decode or skip the value.
+func decodeObject(dec *json.Decoder, fn func(key string) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return eofError(err)
+		}
+
+		key, _ := t.(string)
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+// skipValue skips the JSON value at the current position of dec one token
+// at a time, so that the value is not kept in memory.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return eofError(err)
+		}
+
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// decodeResourceValue decodes the value of a resource field into v if the
+// resource is used by the inventory, and skips it if it is not. Terraform
+// writes the type of a resource before its objects, but if the type is
+// not known yet, the value is kept in raw until it is.
+func decodeResourceValue(dec *json.Decoder, used *bool, v interface{}, raw *json.RawMessage) error {
+	switch {
+	case used == nil:
+		return dec.Decode(raw)
+	case *used:
+		return dec.Decode(v)
+	}
+
+	return skipValue(dec)
+}
+
+// expectDelim reads the next token of dec, which must be the delimiter d.
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return eofError(err)
+	}
+
+	if t != d {
+		return fmt.Errorf("Expected %s but found %v", d, t)
+	}
+
+	return nil
+}
+
+// isAnsibleResource reports whether a resource type is used by the
+// inventory, such as ansible_host and ansible_group.
+func isAnsibleResource(resourceType string) bool {
+	return strings.HasPrefix(resourceType, "ansible_")
+}
+
+// eofError turns the end of the input into io.ErrUnexpectedEOF, since the
+// state is not complete yet.
+func eofError(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// decodeError turns an error in the fields of a state into a stateError,
+// unless the state is truncated.
+func decodeError(err error) error {
+	err = eofError(err)
+	if err == io.ErrUnexpectedEOF {
+		return err
+	}
+
+	return stateError{fmt.Errorf("Error unmarshaling state: %s\n", err)}
+}
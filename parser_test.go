@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// parseState is a test helper which decodes a raw state through
+// extractState, where nothing but the state may be given.
+func parseState(b []byte) (State, error) {
+	state, skipped, err := extractState(b)
+	if err == nil && len(skipped) > 0 {
+		return nil, fmt.Errorf("Unexpected output around the state: %q", skipped)
+	}
+
+	return state, err
+}
+
+const testStateV2 = `{
+  "version": 2,
+  "terraform_version": "0.7.13",
+  "serial": 4,
+  "lineage": "3c0b7c1e-4bd2-4f5e-9d5e-2b4e2b8c7f9a",
+  "modules": [
+    {
+      "path": ["root"],
+      "resources": {
+        "ansible_host.web": {
+          "type": "ansible_host",
+          "primary": {
+            "id": "web",
+            "attributes": {
+              "inventory_hostname": "web",
+              "groups.#": "1",
+              "groups.0": "app",
+              "vars.%": "1",
+              "vars.ansible_host": "10.0.0.1"
+            }
+          }
+        }
+      }
+    }
+  ]
+}`
+
+func TestParseState_legacy(t *testing.T) {
+	state, err := parseState([]byte(testStateV2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.IsType(t, StateV011{}, state)
+	assert.Equal(t, []StateRevision{{Serial: 4, Lineage: "3c0b7c1e-4bd2-4f5e-9d5e-2b4e2b8c7f9a"}}, state.GetRevisions())
+
+	hosts, err := state.GetHosts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web"}, hosts)
+}
+
+func TestParseState_unsupported(t *testing.T) {
+	_, err := parseState([]byte(`{"version": 5, "terraform_version": "2.0.0", "resources": []}`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported state version 5 (terraform_version 2.0.0)")
+	}
+
+	_, err = parseState([]byte(`{"resources": []}`))
+	assert.Error(t, err)
+
+	_, err = parseState([]byte(`{"version": null}`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "No state found")
+	}
+}
+
+func TestParseState_skipsResources(t *testing.T) {
+	// The instances of the ansible_host come before its type, and the
+	// version comes last.
+	state, err := parseState([]byte(`{
+  "serial": 2,
+  "resources": [
+    {"mode": "managed", "type": "aws_instance", "name": "web", "instances": [{"attributes": {"tags": {"a": [1, {"b": null}]}}}]},
+    {"instances": [{"attributes": {"inventory_hostname": "web"}}], "name": "web", "type": "ansible_host", "mode": "managed"},
+    {"mode": "data", "type": "ansible_host", "name": "lookup", "instances": [{"attributes": {"inventory_hostname": "lookup"}}]}
+  ],
+  "version": 4
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, StateV012{
+		Serial: 2,
+		Resources: []ResourceV012{{
+			Type:      "ansible_host",
+			Name:      "web",
+			Instances: []InstanceV012{{Attributes: map[string]interface{}{"inventory_hostname": "web"}}},
+		}},
+	}, state)
+}
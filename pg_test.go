@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPGV012_fetch requires a PostgreSQL database, such as a local
+// instance, specified by TF_PG_TEST_CONN_STR.
+func TestPGV012_fetch(t *testing.T) {
+	connStr := os.Getenv("TF_PG_TEST_CONN_STR")
+	if connStr == "" {
+		t.Skip("TF_PG_TEST_CONN_STR is not set")
+	}
+
+	b, err := ioutil.ReadFile("fixtures/v012/nbering-ansible/terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	statements := []string{
+		`DROP SCHEMA IF EXISTS inventory_test CASCADE`,
+		`CREATE SCHEMA inventory_test`,
+		`CREATE TABLE inventory_test.states (id SERIAL PRIMARY KEY, name TEXT, data TEXT)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec(`DROP SCHEMA inventory_test CASCADE`)
+
+	for _, name := range []string{"default", "staging"} {
+		if _, err := db.Exec(`INSERT INTO inventory_test.states (name, data) VALUES ($1, $2)`, name, string(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer setenv("TF_PG_CONN_STR", connStr)()
+	defer setenv("PG_SCHEMA_NAME", "inventory_test")()
+	defer setenv("TF_WORKSPACES", "*")()
+
+	workspaces, err := newPGSourceFromEnv().ListWorkspaces(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{"default", "staging"}, workspaces)
+
+	state, err := loadState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actualInventory, err := BuildInventory(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedInventoryV012, actualInventory)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how often and how long to wait before an
+// operation which failed with a transient error is tried again.
+type RetryPolicy struct {
+	// Attempts is the number of times the operation is tried.
+	Attempts int
+
+	// BaseDelay is the delay before the second attempt. It doubles
+	// with every further attempt up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	Attempts:  3,
+	BaseDelay: time.Second,
+	MaxDelay:  30 * time.Second,
+}
+
+// transientError matches the messages of errors which may go away when
+// the operation is tried again, such as network errors, rate limiting and
+// locked states. HTTP 5xx errors in the output of commands are only
+// matched next to a status code, so that numbers in other messages are not
+// mistaken for them.
+var transientError = regexp.MustCompile(`(?i)` +
+	`(status ?code:? *|http/\d(\.\d)? +)(429|5\d\d)\b|` +
+	`too many requests|rate limit|rate exceeded|throttl|slowdown|` +
+	`connection refused|connection reset|broken pipe|unexpected eof|` +
+	`i/o timeout|handshake timeout|timeout awaiting response headers|` +
+	`temporary failure in name resolution|` +
+	`state lock|state is locked`)
+
+// isTransient reports whether an error may go away when the operation is
+// tried again.
+func isTransient(err error) bool {
+	if e, ok := err.(*statusError); ok {
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	}
+
+	return transientError.MatchString(err.Error())
+}
+
+// getRetryPolicy returns the retry policy, where the number of attempts
+// can be given with --retries or TF_INVENTORY_RETRIES.
+func getRetryPolicy() (RetryPolicy, error) {
+	p := defaultRetryPolicy
+
+	if *retries >= 0 {
+		p.Attempts = *retries + 1
+		return p, nil
+	}
+
+	if v := os.Getenv("TF_INVENTORY_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("Invalid TF_INVENTORY_RETRIES: %s", v)
+		}
+		p.Attempts = n + 1
+	}
+
+	return p, nil
+}
+
+// Do calls fn until it succeeds, fails with an error which is not
+// transient, or all attempts are used up. The delay between attempts
+// grows exponentially and is randomized, so that concurrent retries do
+// not hit a backend at the same time.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.Attempts || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		debugf("Retrying in %s after attempt %d failed: %s", delay, attempt, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// delay returns the delay after the given attempt. Half of the delay is
+// random.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
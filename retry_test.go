@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := map[string]bool{
+		"Error running `terraform state pull` in directory ., exit status 1: StatusCode: 503, RequestID: x":  true,
+		"Error running `terraform state pull` in directory ., exit status 1: api error: status code 429":     true,
+		"Error running `terraform state pull` in directory ., exit status 1: HTTP/1.1 502 Bad Gateway":       true,
+		"Error running `terraform state pull` in directory ., exit status 1: status code: 403":               false,
+		"Error running `terraform state pull` in directory ., exit status 1: created 502 resources":          false,
+		"Error reading state from stdin: 500 hosts in module.web":                                            false,
+		"dial tcp 10.0.0.1:443: connect: connection refused":                                                 true,
+		"Error running `terraform state pull` in directory ., exit status 1: Error acquiring the state lock": true,
+		"Error running `terraform state pull` in directory ., exit status 1: Invalid backend configuration":  false,
+		"Error determining directory: stat /nothing: no such file or directory":                              false,
+		"Error unmarshaling state: invalid character 'x' looking for beginning of value":                     false,
+	}
+
+	for msg, expected := range tests {
+		assert.Equal(t, expected, isTransient(fmt.Errorf("%s", msg)), msg)
+	}
+
+	for code, expected := range map[int]bool{503: true, 429: true, 403: false, 400: false} {
+		resp := &http.Response{StatusCode: code, Status: fmt.Sprintf("%d %s", code, http.StatusText(code))}
+		assert.Equal(t, expected, isTransient(newStatusError(resp, nil, "Error fetching state from %s", "s3://bucket/key")), code)
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	p := RetryPolicy{Attempts: 3}
+
+	calls := 0
+	err := p.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return &statusError{StatusCode: http.StatusBadGateway, message: "502 Bad Gateway"}
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = p.Do(context.Background(), func() error {
+		calls++
+		return &statusError{StatusCode: http.StatusNotFound, message: "404 Not Found"}
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	err = p.Do(context.Background(), func() error {
+		calls++
+		return fmt.Errorf("connection reset by peer")
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := RetryPolicy{Attempts: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 9: 5 * time.Second} {
+		d := p.delay(attempt)
+		assert.True(t, d >= max/2 && d <= max, "delay %s of attempt %d should be between %s and %s", d, attempt, max/2, max)
+	}
+}
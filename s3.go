@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region             = "us-east-1"
+	defaultS3WorkspaceKeyPrefix = "env:"
+
+	// emptyPayloadHash is the SHA256 of an empty request body.
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Source reads a state stored by Terraform's s3 backend. Any
+// S3-compatible service, such as MinIO, can be used by setting Endpoint.
+type S3Source struct {
+	Bucket             string
+	Key                string
+	Region             string
+	Endpoint           string
+	WorkspaceKeyPrefix string
+	Workspace          string
+
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	// Profile selects the keys in CredentialsFile, the shared credentials
+	// file of the AWS CLI, which are used if no keys are set.
+	Profile         string
+	CredentialsFile string
+
+	Client *http.Client
+}
+
+func init() {
+	RegisterStateSource("s3", newS3SourceFromURL)
+}
+
+// newS3SourceFromURL creates an S3Source from a URL such as
+// s3://bucket/path/terraform.tfstate?region=eu-west-1&workspace=staging.
+// The endpoint and workspace_key_prefix parameters are also supported.
+// Credentials are read from the environment.
+func newS3SourceFromURL(u *url.URL) (StateSource, error) {
+	s := newS3SourceFromEnv()
+	s.Bucket = u.Host
+	s.Key = strings.TrimPrefix(u.Path, "/")
+	s.Region = queryDefault(u, "region", s.Region)
+	s.Endpoint = queryDefault(u, "endpoint", s.Endpoint)
+	s.Workspace = queryDefault(u, "workspace", s.Workspace)
+
+	if v, ok := u.Query()["workspace_key_prefix"]; ok && len(v) > 0 {
+		s.WorkspaceKeyPrefix = v[0]
+	}
+
+	return s, nil
+}
+
+// newS3SourceFromEnv creates an S3Source from the environment. The
+// settings mirror the arguments of Terraform's s3 backend.
+func newS3SourceFromEnv() *S3Source {
+	s := &S3Source{
+		Bucket:             os.Getenv("TF_S3_BUCKET"),
+		Key:                os.Getenv("TF_S3_KEY"),
+		Region:             firstEnv("TF_S3_REGION", "AWS_REGION", "AWS_DEFAULT_REGION"),
+		Endpoint:           firstEnv("TF_S3_ENDPOINT", "AWS_S3_ENDPOINT"),
+		WorkspaceKeyPrefix: defaultS3WorkspaceKeyPrefix,
+		Workspace:          getWorkspace(),
+		AccessKey:          os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:          os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:       os.Getenv("AWS_SESSION_TOKEN"),
+		Profile:            os.Getenv("AWS_PROFILE"),
+		CredentialsFile:    os.Getenv("AWS_SHARED_CREDENTIALS_FILE"),
+	}
+
+	if s.CredentialsFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			s.CredentialsFile = filepath.Join(home, ".aws", "credentials")
+		}
+	}
+
+	if v, ok := os.LookupEnv("TF_S3_WORKSPACE_KEY_PREFIX"); ok {
+		s.WorkspaceKeyPrefix = v
+	}
+
+	if s.Region == "" {
+		s.Region = defaultS3Region
+	}
+
+	return s
+}
+
+// ObjectKey returns the key of the state object for the configured
+// workspace. Non-default workspaces are stored under
+// <workspace_key_prefix>/<workspace>/<key>.
+func (s *S3Source) ObjectKey() string {
+	if s.Workspace == "" || s.Workspace == defaultWorkspace {
+		return s.Key
+	}
+
+	return path.Join(s.WorkspaceKeyPrefix, s.Workspace, s.Key)
+}
+
+// URL returns the URL of the state object. Path-style addressing is used
+// when a custom endpoint is set.
+func (s *S3Source) URL() (*url.URL, error) {
+	return s.objectURL(s.ObjectKey())
+}
+
+// objectURL returns the URL of an object of the bucket, or of the bucket
+// itself if key is empty.
+func (s *S3Source) objectURL(key string) (*url.URL, error) {
+	escapedKey := uriEncode(key, true)
+
+	if s.Endpoint == "" {
+		return url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, escapedKey))
+	}
+
+	endpoint := s.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(u.EscapedPath(), "/")
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket
+	u.RawPath = base + "/" + uriEncode(s.Bucket, false)
+
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + escapedKey
+	}
+
+	return u, nil
+}
+
+// Fetch downloads the state object. If the object does not exist, no
+// bytes and no error are returned.
+func (s *S3Source) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	meta := StateMetadata{
+		Source:    fmt.Sprintf("s3://%s/%s", s.Bucket, s.ObjectKey()),
+		Workspace: s.Workspace,
+	}
+
+	if s.Bucket == "" || s.Key == "" {
+		return nil, meta, fmt.Errorf("Both an S3 bucket and key must be specified")
+	}
+
+	u, err := s.URL()
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error building S3 URL: %s", err)
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error building S3 request: %s", err)
+	}
+	req = req.WithContext(ctx)
+
+	if err := s.sign(req); err != nil {
+		return nil, meta, err
+	}
+
+	b, err := fetchObject(s.Client, req, meta.Source)
+	return b, meta, err
+}
+
+// sign signs a request with the configured keys, or with those of the
+// profile in the shared credentials file. Without any keys, the request
+// is sent unsigned, which is enough for public buckets.
+func (s *S3Source) sign(req *http.Request) error {
+	accessKey, secretKey, sessionToken := s.AccessKey, s.SecretKey, s.SessionToken
+
+	if accessKey == "" || secretKey == "" {
+		var err error
+		accessKey, secretKey, sessionToken, err = readSharedCredentials(s.CredentialsFile, s.Profile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if accessKey != "" && secretKey != "" {
+		signV4(req, accessKey, secretKey, sessionToken, s.Region, "s3", time.Now())
+	}
+
+	return nil
+}
+
+// readSharedCredentials returns the keys of a profile in a shared
+// credentials file, an INI file such as ~/.aws/credentials. A missing file
+// or profile is only an error if the profile was selected.
+func readSharedCredentials(file, profile string) (accessKey, secretKey, sessionToken string, err error) {
+	if file == "" {
+		return "", "", "", nil
+	}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) && profile == "" {
+		return "", "", "", nil
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("Error reading AWS credentials: %s", err)
+	}
+	defer f.Close()
+
+	selected := profile != ""
+	if !selected {
+		profile = "default"
+	}
+
+	var section string
+	found := false
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			found = found || section == profile
+			continue
+		}
+
+		pieces := strings.SplitN(line, "=", 2)
+		if section != profile || len(pieces) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(pieces[1])
+		switch strings.ToLower(strings.TrimSpace(pieces[0])) {
+		case "aws_access_key_id":
+			accessKey = value
+		case "aws_secret_access_key":
+			secretKey = value
+		case "aws_session_token":
+			sessionToken = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", "", fmt.Errorf("Error reading AWS credentials: %s", err)
+	}
+
+	if !found && selected {
+		return "", "", "", fmt.Errorf("The AWS profile %s was not found in %s", profile, file)
+	}
+
+	return accessKey, secretKey, sessionToken, nil
+}
+
+// signV4 signs a request with AWS Signature Version 4. Only requests
+// without a body are supported.
+func signV4(req *http.Request, accessKey, secretKey, sessionToken, region, service string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "authorization" || k == "user-agent" {
+			continue
+		}
+		headers[k] = strings.TrimSpace(strings.Join(v, ","))
+	}
+
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := req.URL.Path
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(canonicalURI, true),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query string in the canonical form
+// required by Signature Version 4.
+func canonicalQuery(q url.Values) string {
+	var pairs []string
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes s as required by Signature Version 4. Slashes are
+// left as-is when keepSlash is true.
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// ListWorkspaces returns the names of all workspaces with a state in the
+// bucket. Like Terraform, the default workspace is always included.
+func (s *S3Source) ListWorkspaces(ctx context.Context) ([]string, error) {
+	workspaces := []string{defaultWorkspace}
+	prefix := s.WorkspaceKeyPrefix + "/"
+
+	var token string
+	for {
+		u, err := s.objectURL("")
+		if err != nil {
+			return nil, fmt.Errorf("Error building S3 URL: %s", err)
+		}
+
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("Error building S3 request: %s", err)
+		}
+		req = req.WithContext(ctx)
+
+		if err := s.sign(req); err != nil {
+			return nil, err
+		}
+
+		b, err := fetchObject(s.Client, req, "s3://"+s.Bucket)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+
+		if err := xml.Unmarshal(b, &result); err != nil {
+			return nil, fmt.Errorf("Error listing workspaces of s3://%s: %s", s.Bucket, err)
+		}
+
+		// Workspace states are stored as <prefix>/<workspace>/<key>.
+		for _, c := range result.Contents {
+			pieces := strings.SplitN(strings.TrimPrefix(c.Key, prefix), "/", 2)
+			if len(pieces) == 2 && pieces[0] != "" && pieces[1] == s.Key {
+				workspaces = append(workspaces, pieces[0])
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return workspaces, nil
+}
+
+// ForWorkspace returns an S3Source for the state of another workspace.
+func (s *S3Source) ForWorkspace(workspace string) StateSource {
+	c := *s
+	c.Workspace = workspace
+	return &c
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StateMetadata describes where a raw state was obtained from.
+type StateMetadata struct {
+	// Source is a human-readable location of the state, such as a URL.
+	Source string
+
+	// Workspace is the Terraform workspace of the state, if known.
+	Workspace string
+}
+
+// Interface StateSource represents a location a raw Terraform state can
+// be fetched from. If no state exists, Fetch returns no bytes and no
+// error.
+type StateSource interface {
+	Fetch(ctx context.Context) ([]byte, StateMetadata, error)
+}
+
+// Interface RevisionSource represents a StateSource which can fetch the
+// revision of its state more cheaply than the state itself.
+type RevisionSource interface {
+	StateSource
+
+	// FetchRevision returns the revision of the state. It returns false
+	// if there is no state.
+	FetchRevision(ctx context.Context) (StateRevision, bool, error)
+}
+
+// StateSourceFactory creates a StateSource from a URL.
+type StateSourceFactory func(u *url.URL) (StateSource, error)
+
+var (
+	stateSourcesMu sync.Mutex
+	stateSources   = make(map[string]StateSourceFactory)
+)
+
+// RegisterStateSource makes a StateSource available for URLs with the
+// given scheme. It panics if the scheme is registered twice.
+func RegisterStateSource(scheme string, factory StateSourceFactory) {
+	stateSourcesMu.Lock()
+	defer stateSourcesMu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if _, ok := stateSources[scheme]; ok {
+		panic(fmt.Sprintf("State source %s is already registered", scheme))
+	}
+
+	stateSources[scheme] = factory
+}
+
+// StateSourceSchemes returns the registered URL schemes.
+func StateSourceSchemes() []string {
+	stateSourcesMu.Lock()
+	defer stateSourcesMu.Unlock()
+
+	var schemes []string
+	for scheme := range stateSources {
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
+
+// NewStateSource creates a StateSource from a URL such as
+// s3://bucket/terraform.tfstate. The scheme selects the registered
+// StateSourceFactory.
+func NewStateSource(location string) (StateSource, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid state source %s: %s", location, err)
+	}
+
+	stateSourcesMu.Lock()
+	factory, ok := stateSources[strings.ToLower(u.Scheme)]
+	stateSourcesMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unsupported state source %s, supported schemes are: %s",
+			location, strings.Join(StateSourceSchemes(), ", "))
+	}
+
+	return factory(u)
+}
+
+// isStateSourceURL reports whether v looks like a state source URL rather
+// than a path.
+func isStateSourceURL(v string) bool {
+	return strings.Contains(v, "://")
+}
+
+func init() {
+	RegisterStateSource("file", newFileSourceFromURL)
+}
+
+// FileSource reads a state file, such as terraform.tfstate or
+// terraform.tfstate.backup, directly from disk.
+type FileSource struct {
+	Path string
+}
+
+// newFileSourceFromURL creates a FileSource from a file:// URL.
+// Relative paths can be given as file://./terraform.tfstate.
+func newFileSourceFromURL(u *url.URL) (StateSource, error) {
+	p := u.Host + u.Path
+	if u.Opaque != "" {
+		p = u.Opaque
+	}
+
+	if p == "" {
+		return nil, fmt.Errorf("A path must be specified in %s", u)
+	}
+
+	return &FileSource{Path: p}, nil
+}
+
+// Fetch reads the state file.
+func (s *FileSource) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	meta := StateMetadata{Source: s.Path}
+
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error reading state file %s: %s\n", s.Path, err)
+	}
+
+	return b, meta, nil
+}
+
+// ReaderSource reads a raw state from a reader, such as stdin.
+type ReaderSource struct {
+	Name   string
+	Reader io.Reader
+}
+
+// Fetch reads the state from the reader.
+func (s *ReaderSource) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	meta := StateMetadata{Source: s.Name}
+
+	b, err := ioutil.ReadAll(s.Reader)
+	if err != nil {
+		return nil, meta, fmt.Errorf("Error reading state: %s\n", err)
+	}
+
+	return b, meta, nil
+}
+
+// queryDefault returns the query parameter key of u, or def if it is not
+// set.
+func queryDefault(u *url.URL, key, def string) string {
+	if v := u.Query().Get(key); v != "" {
+		return v
+	}
+
+	return def
+}
@@ -20,14 +20,43 @@ type State interface {
 	GetHosts() ([]string, error)
 	GetHost(host string) (interface{}, error)
 	GetHostsForGroup(group string) ([]string, error)
+
+	GetRevisions() []StateRevision
+}
+
+// StateRevision identifies a revision of a state. The lineage is assigned
+// when a state is created and the serial is incremented on every change.
+type StateRevision struct {
+	Serial  int64  `json:"serial"`
+	Lineage string `json:"lineage"`
+}
+
+// Matches reports whether r is the same revision as v. An empty lineage,
+// which some sources do not report, matches any lineage.
+func (r StateRevision) Matches(v StateRevision) bool {
+	if r.Lineage != "" && v.Lineage != "" && r.Lineage != v.Lineage {
+		return false
+	}
+
+	return r.Serial == v.Serial
 }
 
 func BuildInventory(state State) (map[string]interface{}, error) {
+	// Index the state once, rather than scanning its resources for
+	// every group and host.
+	state, err := indexState(state)
+	if err != nil {
+		return nil, err
+	}
+
 	inv := make(map[string]interface{})
 	meta := make(map[string]interface{})
 	hostvars := make(map[string]interface{})
 	allHosts := []string{}
 
+	// The hosts of each group in the inventory, to check membership.
+	members := make(map[string]map[string]bool)
+
 	// Get all ansible_group resources.
 	groups, err := state.GetGroups()
 	if err != nil {
@@ -116,14 +145,15 @@ func BuildInventory(state State) (map[string]interface{}, error) {
 			if v, ok := inv[group]; ok {
 				groupInventory := v.(map[string]interface{})
 				if hostInventory, ok := groupInventory["hosts"].([]string); ok {
-					var found bool
-					for _, h := range hostInventory {
-						if h == host {
-							found = true
+					if members[group] == nil {
+						members[group] = make(map[string]bool)
+						for _, h := range hostInventory {
+							members[group][h] = true
 						}
 					}
 
-					if !found {
+					if !members[group][host] {
+						members[group][host] = true
 						groupInventory["hosts"] = append(hostInventory, host)
 					}
 				}
@@ -158,11 +188,36 @@ func BuildInventory(state State) (map[string]interface{}, error) {
 	}
 
 	meta["hostvars"] = hostvars
+	meta["terraform_states"] = state.GetRevisions()
 	inv["_meta"] = meta
 
 	return inv, nil
 }
 
+// terraformVars returns the variables of a host with the module address of
+// the host added as terraform_module, unless it is in the root module, and
+// terraform_tainted added if it is tainted.
+func terraformVars(vars map[string]interface{}, module string, tainted bool) map[string]interface{} {
+	if module == "" && !tainted {
+		return vars
+	}
+
+	result := make(map[string]interface{}, len(vars)+2)
+	for k, v := range vars {
+		result[k] = v
+	}
+
+	if module != "" {
+		result["terraform_module"] = module
+	}
+
+	if tainted {
+		result["terraform_tainted"] = true
+	}
+
+	return result
+}
+
 func ToJSON(state State) (string, error) {
 	var s string
 
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// StateGrouped adds all hosts of a state to an additional group, such as
+// a group named after the workspace the state belongs to.
+type StateGrouped struct {
+	State State
+	Group string
+}
+
+// GetGroups will return the groups of the state and the additional group.
+func (r StateGrouped) GetGroups() ([]string, error) {
+	groups, err := r.State.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	return uniqueSorted(append(groups, r.Group)), nil
+}
+
+// GetHosts will return the hosts of the state.
+func (r StateGrouped) GetHosts() ([]string, error) {
+	return r.State.GetHosts()
+}
+
+// GetGroup will return a group of the state. The additional group is
+// returned as its name.
+func (r StateGrouped) GetGroup(group string) (interface{}, error) {
+	v, err := r.State.GetGroup(group)
+	if err != nil && group == r.Group {
+		return group, nil
+	}
+
+	return v, err
+}
+
+// GetHost will return a host of the state.
+func (r StateGrouped) GetHost(host string) (interface{}, error) {
+	return r.State.GetHost(host)
+}
+
+// GetChildrenForGroup will return the children of a group.
+func (r StateGrouped) GetChildrenForGroup(group string) ([]string, error) {
+	if r.isAdditional(group) {
+		return nil, nil
+	}
+
+	return r.State.GetChildrenForGroup(group)
+}
+
+// GetVarsForGroup will return the variables of a group.
+func (r StateGrouped) GetVarsForGroup(group string) (map[string]interface{}, error) {
+	if r.isAdditional(group) {
+		return map[string]interface{}{}, nil
+	}
+
+	return r.State.GetVarsForGroup(group)
+}
+
+// GetHostsForGroup will return the hosts of a group. All hosts belong to
+// the additional group.
+func (r StateGrouped) GetHostsForGroup(group string) ([]string, error) {
+	if group == r.Group {
+		return r.State.GetHosts()
+	}
+
+	return r.State.GetHostsForGroup(group)
+}
+
+// GetGroupsForHost will return the groups of a host, including the
+// additional group.
+func (r StateGrouped) GetGroupsForHost(host string) ([]string, error) {
+	groups, err := r.State.GetGroupsForHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		if g == r.Group {
+			return groups, nil
+		}
+	}
+
+	return append(groups, r.Group), nil
+}
+
+// GetVarsForHost will return the variables of a host.
+func (r StateGrouped) GetVarsForHost(host string) (map[string]interface{}, error) {
+	return r.State.GetVarsForHost(host)
+}
+
+// GetRevisions will return the revisions of the state.
+func (r StateGrouped) GetRevisions() []StateRevision {
+	return r.State.GetRevisions()
+}
+
+// isAdditional reports whether group is the additional group and not
+// also defined by the state itself.
+func (r StateGrouped) isAdditional(group string) bool {
+	if group != r.Group {
+		return false
+	}
+
+	_, err := r.State.GetGroup(group)
+	return err != nil
+}
+
+var invalidGroupChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// groupName builds a valid Ansible group name from a prefix and a name,
+// such as workspace_staging.
+func groupName(prefix, name string) string {
+	name = strings.Trim(invalidGroupChars.ReplaceAllString(name, "_"), "_")
+	return fmt.Sprintf("%s_%s", prefix, name)
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StateIndexed is a state whose groups and hosts are looked up in maps
+// instead of by scanning the resources. BuildInventory indexes a state
+// once, so that building an inventory takes linear time in the number of
+// resources.
+type StateIndexed struct {
+	groups    []string
+	hosts     []string
+	revisions []StateRevision
+
+	groupObjects map[string]interface{}
+	hostObjects  map[string]interface{}
+
+	children   map[string][]string
+	groupVars  map[string]map[string]interface{}
+	groupHosts map[string][]string
+
+	hostVars   map[string]map[string]interface{}
+	hostGroups map[string][]string
+
+	// The groups and hosts which were listed already.
+	listedGroups map[string]bool
+	listedHosts  map[string]bool
+}
+
+func newStateIndexed() *StateIndexed {
+	return &StateIndexed{
+		groupObjects: make(map[string]interface{}),
+		hostObjects:  make(map[string]interface{}),
+		children:     make(map[string][]string),
+		groupVars:    make(map[string]map[string]interface{}),
+		groupHosts:   make(map[string][]string),
+		hostVars:     make(map[string]map[string]interface{}),
+		hostGroups:   make(map[string][]string),
+		listedGroups: make(map[string]bool),
+		listedHosts:  make(map[string]bool),
+	}
+}
+
+// indexState builds a new index of a state. The versioned states are
+// indexed in a single pass over their resources, merged and grouped states
+// extend the indexes of their states, and any other state is indexed
+// through its methods.
+func indexState(state State) (*StateIndexed, error) {
+	switch s := state.(type) {
+	case StateV012:
+		return s.index(), nil
+	case StateV011:
+		return s.index(), nil
+	case StateMerged:
+		return s.index()
+	case StateGrouped:
+		return s.index()
+	}
+
+	return indexGeneric(state)
+}
+
+// indexGeneric indexes a state through the methods of the State interface.
+func indexGeneric(state State) (*StateIndexed, error) {
+	idx := newStateIndexed()
+	idx.revisions = state.GetRevisions()
+
+	var err error
+	if idx.groups, err = state.GetGroups(); err != nil {
+		return nil, err
+	}
+	idx.groups = uniqueSorted(idx.groups)
+
+	if idx.hosts, err = state.GetHosts(); err != nil {
+		return nil, err
+	}
+	idx.hosts = uniqueSorted(idx.hosts)
+
+	for _, group := range idx.groups {
+		if _, ok := idx.groupObjects[group]; ok {
+			continue
+		}
+
+		if idx.groupObjects[group], err = state.GetGroup(group); err != nil {
+			return nil, err
+		}
+
+		if idx.children[group], err = state.GetChildrenForGroup(group); err != nil {
+			return nil, err
+		}
+
+		if idx.groupVars[group], err = state.GetVarsForGroup(group); err != nil {
+			return nil, err
+		}
+	}
+
+	// The hosts of the groups which are only named by hosts are indexed
+	// as well.
+	groups := append([]string{}, idx.groups...)
+
+	for _, host := range idx.hosts {
+		if _, ok := idx.hostObjects[host]; ok {
+			continue
+		}
+
+		if idx.hostObjects[host], err = state.GetHost(host); err != nil {
+			return nil, err
+		}
+
+		if idx.hostVars[host], err = state.GetVarsForHost(host); err != nil {
+			return nil, err
+		}
+
+		if idx.hostGroups[host], err = state.GetGroupsForHost(host); err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, idx.hostGroups[host]...)
+	}
+
+	for _, group := range uniqueSorted(groups) {
+		hosts, err := state.GetHostsForGroup(group)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(hosts) > 0 {
+			idx.groupHosts[group] = uniqueSorted(hosts)
+		}
+	}
+
+	return idx, nil
+}
+
+// addGroup adds a group to the index, listed under its name. The group is
+// looked up by its name and by its other names, such as the name
+// attribute of an ansible_group with an inventory_group_name. The first
+// definition of a name wins.
+func (r *StateIndexed) addGroup(name string, others []string, object interface{}, children []string, vars map[string]interface{}) {
+	if !r.listedGroups[name] {
+		r.listedGroups[name] = true
+		r.groups = append(r.groups, name)
+	}
+
+	sort.Strings(children)
+
+	for _, n := range append([]string{name}, others...) {
+		if _, ok := r.groupObjects[n]; ok {
+			continue
+		}
+
+		r.groupObjects[n] = object
+		r.children[n] = children
+		r.groupVars[n] = vars
+	}
+}
+
+// addHost adds a host to the index, like addGroup. Every definition of a
+// host belongs to its groups.
+func (r *StateIndexed) addHost(name string, others []string, object interface{}, groups []string, vars map[string]interface{}) {
+	if !r.listedHosts[name] {
+		r.listedHosts[name] = true
+		r.hosts = append(r.hosts, name)
+	}
+
+	for _, g := range groups {
+		r.groupHosts[g] = append(r.groupHosts[g], name)
+	}
+
+	for _, n := range append([]string{name}, others...) {
+		if _, ok := r.hostObjects[n]; ok {
+			continue
+		}
+
+		r.hostObjects[n] = object
+		r.hostGroups[n] = groups
+		r.hostVars[n] = vars
+	}
+}
+
+// sort sorts the groups and hosts once all resources were added.
+func (r *StateIndexed) sort() *StateIndexed {
+	sort.Strings(r.groups)
+	sort.Strings(r.hosts)
+
+	for group, hosts := range r.groupHosts {
+		r.groupHosts[group] = uniqueSorted(hosts)
+	}
+
+	return r
+}
+
+// index indexes the ansible_group and ansible_host resources of the state.
+func (r StateV012) index() *StateIndexed {
+	idx := newStateIndexed()
+	idx.revisions = r.GetRevisions()
+
+	for _, resource := range r.Resources {
+		if resource.Type != "ansible_group" && resource.Type != "ansible_host" {
+			continue
+		}
+
+		nameAttr := "inventory_hostname"
+		if resource.Type == "ansible_group" {
+			nameAttr = "inventory_group_name"
+		}
+
+		for _, instance := range resource.Instances {
+			// An instance is listed under the name attribute only if it
+			// has no inventory name, but is found by either of them.
+			var names []string
+			for _, attr := range []string{nameAttr, "name"} {
+				if v, ok := instance.Attributes[attr].(string); ok {
+					names = append(names, v)
+				}
+			}
+
+			if len(names) == 0 {
+				continue
+			}
+			name, others := names[0], names[1:]
+
+			vars := make(map[string]interface{})
+			if v, ok := instance.Attributes["vars"].(map[string]interface{}); ok {
+				vars = v
+			}
+			if v, ok := instance.Attributes["variables"].(map[string]interface{}); ok {
+				vars = v
+			}
+
+			if resource.Type == "ansible_group" {
+				var children []string
+				if v, ok := instance.Attributes["children"].([]interface{}); ok {
+					for _, c := range v {
+						children = append(children, c.(string))
+					}
+				}
+
+				idx.addGroup(name, others, instance, children, vars)
+				continue
+			}
+
+			groups := []string{}
+			if v, ok := instance.Attributes["groups"].([]interface{}); ok {
+				for _, g := range v {
+					groups = append(groups, g.(string))
+				}
+			}
+
+			idx.addHost(name, others, instance, groups, terraformVars(vars, resource.Module, instance.Status == statusTainted))
+		}
+	}
+
+	return idx.sort()
+}
+
+// index indexes the ansible_group and ansible_host resources of the state.
+func (r StateV011) index() *StateIndexed {
+	idx := newStateIndexed()
+	idx.revisions = r.GetRevisions()
+
+	for _, m := range r.Modules {
+		for _, resource := range m.Resources {
+			if resource.Type != "ansible_group" && resource.Type != "ansible_host" {
+				continue
+			}
+
+			var (
+				list []string
+				vars = make(map[string]interface{})
+			)
+
+			listPrefix := "groups."
+			if resource.Type == "ansible_group" {
+				listPrefix = "children."
+			}
+
+			for attrName, attr := range resource.Primary.Attributes {
+				switch {
+				case attrName == listPrefix+"#" || attrName == "vars.%":
+				case strings.HasPrefix(attrName, listPrefix):
+					list = append(list, attr)
+				case strings.HasPrefix(attrName, "vars."):
+					vars[strings.TrimPrefix(attrName, "vars.")] = attr
+				}
+			}
+
+			if resource.Type == "ansible_group" {
+				idx.addGroup(resource.Primary.ID, nil, resource, list, vars)
+				continue
+			}
+
+			if list == nil {
+				list = []string{}
+			}
+
+			idx.addHost(resource.Primary.ID, nil, resource, list, terraformVars(vars, moduleAddress(m.Path), resource.Primary.Tainted))
+		}
+	}
+
+	return idx.sort()
+}
+
+// index combines the indexes of the states.
+func (r StateMerged) index() (*StateIndexed, error) {
+	idx := newStateIndexed()
+
+	var groups, hosts []string
+	groupHosts := make(map[string][]string)
+	seenHostGroups := make(map[string]map[string]bool)
+
+	for _, s := range r.States {
+		v, err := indexState(s)
+		if err != nil {
+			return nil, err
+		}
+
+		idx.revisions = append(idx.revisions, v.revisions...)
+		groups = append(groups, v.groups...)
+		hosts = append(hosts, v.hosts...)
+
+		for group, object := range v.groupObjects {
+			if _, ok := idx.groupObjects[group]; !ok {
+				idx.groupObjects[group] = object
+				idx.groupVars[group] = make(map[string]interface{})
+			}
+
+			idx.children[group] = append(idx.children[group], v.children[group]...)
+			for k, value := range v.groupVars[group] {
+				idx.groupVars[group][k] = value
+			}
+		}
+
+		for group, h := range v.groupHosts {
+			groupHosts[group] = append(groupHosts[group], h...)
+		}
+
+		for host, object := range v.hostObjects {
+			if _, ok := idx.hostObjects[host]; !ok {
+				idx.hostObjects[host] = object
+				idx.hostVars[host] = make(map[string]interface{})
+				idx.hostGroups[host] = []string{}
+				seenHostGroups[host] = make(map[string]bool)
+			}
+
+			for k, value := range v.hostVars[host] {
+				idx.hostVars[host][k] = value
+			}
+
+			for _, g := range v.hostGroups[host] {
+				if !seenHostGroups[host][g] {
+					seenHostGroups[host][g] = true
+					idx.hostGroups[host] = append(idx.hostGroups[host], g)
+				}
+			}
+		}
+	}
+
+	idx.groups = uniqueSorted(groups)
+	idx.hosts = uniqueSorted(hosts)
+
+	for group, children := range idx.children {
+		idx.children[group] = uniqueSorted(children)
+	}
+
+	for group, h := range groupHosts {
+		idx.groupHosts[group] = uniqueSorted(h)
+	}
+
+	return idx, nil
+}
+
+// index adds the additional group to the index of the state.
+func (r StateGrouped) index() (*StateIndexed, error) {
+	idx, err := indexState(r.State)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := idx.groupObjects[r.Group]; !ok {
+		idx.groupObjects[r.Group] = r.Group
+		idx.children[r.Group] = nil
+		idx.groupVars[r.Group] = map[string]interface{}{}
+	}
+
+	idx.groups = uniqueSorted(append(idx.groups, r.Group))
+
+	if len(idx.hosts) > 0 {
+		idx.groupHosts[r.Group] = idx.hosts
+	} else {
+		delete(idx.groupHosts, r.Group)
+	}
+
+	for host, groups := range idx.hostGroups {
+		found := false
+		for _, g := range groups {
+			if g == r.Group {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			idx.hostGroups[host] = append(groups, r.Group)
+		}
+	}
+
+	return idx, nil
+}
+
+// GetGroups will return all groups.
+func (r *StateIndexed) GetGroups() ([]string, error) {
+	return r.groups, nil
+}
+
+// GetGroup will return a specific group.
+func (r *StateIndexed) GetGroup(group string) (interface{}, error) {
+	if v, ok := r.groupObjects[group]; ok {
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("Unable to find group %s", group)
+}
+
+// GetGroupsForHost will return the groups of a host.
+func (r *StateIndexed) GetGroupsForHost(host string) ([]string, error) {
+	if v, ok := r.hostGroups[host]; ok {
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("Unable to find host %s", host)
+}
+
+// GetChildrenForGroup will return the children of a group.
+func (r *StateIndexed) GetChildrenForGroup(group string) ([]string, error) {
+	if _, ok := r.groupObjects[group]; !ok {
+		return nil, fmt.Errorf("Unable to find group %s", group)
+	}
+
+	return r.children[group], nil
+}
+
+// GetVarsForGroup will return the variables of a group.
+func (r *StateIndexed) GetVarsForGroup(group string) (map[string]interface{}, error) {
+	if v, ok := r.groupVars[group]; ok {
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("Unable to find group %s", group)
+}
+
+// GetVarsForHost will return the variables of a host.
+func (r *StateIndexed) GetVarsForHost(host string) (map[string]interface{}, error) {
+	if v, ok := r.hostVars[host]; ok {
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("Unable to find host %s", host)
+}
+
+// GetHosts will return all hosts.
+func (r *StateIndexed) GetHosts() ([]string, error) {
+	return r.hosts, nil
+}
+
+// GetHost will return a specific host.
+func (r *StateIndexed) GetHost(host string) (interface{}, error) {
+	if v, ok := r.hostObjects[host]; ok {
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("Unable to find host %s", host)
+}
+
+// GetHostsForGroup will return the hosts of a group.
+func (r *StateIndexed) GetHostsForGroup(group string) ([]string, error) {
+	return r.groupHosts[group], nil
+}
+
+// GetRevisions will return the revisions of the state.
+func (r *StateIndexed) GetRevisions() []StateRevision {
+	return r.revisions
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertSameState checks that every method of the index returns the same
+// as the state it was built from.
+func assertSameState(t *testing.T, expected State, actual State) {
+	groups, err := expected.GetGroups()
+	assert.NoError(t, err)
+	actualGroups, err := actual.GetGroups()
+	assert.NoError(t, err)
+	assert.Equal(t, groups, actualGroups)
+
+	hosts, err := expected.GetHosts()
+	assert.NoError(t, err)
+	actualHosts, err := actual.GetHosts()
+	assert.NoError(t, err)
+	assert.Equal(t, hosts, actualHosts)
+
+	assert.Equal(t, expected.GetRevisions(), actual.GetRevisions())
+
+	for _, group := range append(groups, "missing") {
+		e, eerr := expected.GetGroup(group)
+		a, aerr := actual.GetGroup(group)
+		assert.Equal(t, e, a, group)
+		assert.Equal(t, eerr, aerr, group)
+
+		ec, eerr := expected.GetChildrenForGroup(group)
+		ac, aerr := actual.GetChildrenForGroup(group)
+		assert.Equal(t, ec, ac, group)
+		assert.Equal(t, eerr, aerr, group)
+
+		ev, eerr := expected.GetVarsForGroup(group)
+		av, aerr := actual.GetVarsForGroup(group)
+		assert.Equal(t, ev, av, group)
+		assert.Equal(t, eerr, aerr, group)
+	}
+
+	memberOf := []string{"missing"}
+
+	for _, host := range append(hosts, "missing") {
+		e, eerr := expected.GetHost(host)
+		a, aerr := actual.GetHost(host)
+		assert.Equal(t, e, a, host)
+		assert.Equal(t, eerr, aerr, host)
+
+		eg, eerr := expected.GetGroupsForHost(host)
+		ag, aerr := actual.GetGroupsForHost(host)
+		assert.ElementsMatch(t, eg, ag, host)
+		assert.Equal(t, eg == nil, ag == nil, host)
+		assert.Equal(t, eerr, aerr, host)
+		memberOf = append(memberOf, eg...)
+
+		ev, eerr := expected.GetVarsForHost(host)
+		av, aerr := actual.GetVarsForHost(host)
+		assert.Equal(t, ev, av, host)
+		assert.Equal(t, eerr, aerr, host)
+	}
+
+	for _, group := range uniqueSorted(append(groups, memberOf...)) {
+		eh, eerr := expected.GetHostsForGroup(group)
+		ah, aerr := actual.GetHostsForGroup(group)
+		assert.Equal(t, eh, ah, group)
+		assert.Equal(t, eerr, aerr, group)
+	}
+}
+
+// testIndexState checks the indexes of states against the states.
+func testIndexState(t *testing.T, states map[string]State) {
+	for name, state := range states {
+		t.Run(name, func(t *testing.T) {
+			idx, err := indexState(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertSameState(t, state, idx)
+
+			generic, err := indexGeneric(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertSameState(t, state, generic)
+		})
+	}
+}
+
+func TestIndexStateV011(t *testing.T) {
+	testIndexState(t, map[string]State{
+		"state":   expectedStateV011,
+		"grouped": StateGrouped{State: expectedStateV011, Group: "all"},
+	})
+}
+
+func TestIndexStateV012(t *testing.T) {
+	testIndexState(t, map[string]State{
+		"state":  expectedStateV012,
+		"merged": StateMerged{States: []State{expectedStateV012, expectedStateV012AnsibleAnsible}},
+		"grouped": StateMerged{States: []State{
+			StateGrouped{State: expectedStateV012, Group: "workspace_default"},
+			StateGrouped{State: expectedStateV012AnsibleAnsible, Group: "workspace_staging"},
+			StateGrouped{State: expectedStateV011, Group: "all"},
+		}},
+		"empty": StateGrouped{State: StateV012{}, Group: "workspace_default"},
+	})
+}
+
+func TestIndexStateV012_duplicates(t *testing.T) {
+	group := InstanceV012{Attributes: map[string]interface{}{
+		"inventory_group_name": "app",
+		"name":                 "app_group",
+		"vars":                 map[string]interface{}{"tier": "app"},
+	}}
+	host := InstanceV012{Attributes: map[string]interface{}{
+		"inventory_hostname": "web",
+		"groups":             []interface{}{"app"},
+	}}
+
+	state := StateV012{Resources: []ResourceV012{
+		{Type: "ansible_group", Name: "app", Instances: []InstanceV012{group}},
+		{Type: "ansible_host", Name: "web", Instances: []InstanceV012{host, host}},
+		{Type: "ansible_host", Name: "other", Instances: []InstanceV012{{Attributes: map[string]interface{}{
+			"inventory_hostname": "other",
+		}}, {Attributes: map[string]interface{}{
+			"inventory_hostname": "other",
+		}}}},
+	}}
+
+	idx, err := indexState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The group is found by either of its names, like StateV012.GetGroup.
+	for _, name := range []string{"app", "app_group"} {
+		expected, err := state.GetGroup(name)
+		assert.NoError(t, err)
+		actual, err := idx.GetGroup(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual, name)
+	}
+
+	groups, _ := idx.GetGroups()
+	assert.Equal(t, []string{"app"}, groups)
+
+	inv, err := BuildInventory(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{"other", "web"}, inv["all"].(map[string]interface{})["hosts"])
+	assert.Equal(t, []string{"web"}, inv["app"].(map[string]interface{})["hosts"])
+	assert.Equal(t, []string{"other"}, inv["ungrouped"].(map[string]interface{})["hosts"])
+}
+
+// generatedResource is a resource of a generated state. The fields of
+// generated states are in the order Terraform writes them.
+type generatedResource struct {
+	Mode      string        `json:"mode"`
+	Type      string        `json:"type"`
+	Name      string        `json:"name"`
+	Instances []interface{} `json:"instances"`
+}
+
+// generateState returns a version 4 state with the given number of hosts,
+// spread over groups, and as many other resources with large attributes.
+func generateState(hosts, groups int) []byte {
+	var resources []generatedResource
+
+	for i := 0; i < groups; i++ {
+		resources = append(resources, generatedResource{
+			Mode: "managed",
+			Type: "ansible_group",
+			Name: fmt.Sprintf("group%d", i),
+			Instances: []interface{}{map[string]interface{}{
+				"attributes": map[string]interface{}{
+					"inventory_group_name": fmt.Sprintf("group%d", i),
+					"children":             []string{fmt.Sprintf("group%d", (i+1)%groups)},
+					"vars":                 map[string]string{"index": fmt.Sprint(i)},
+				},
+			}},
+		})
+	}
+
+	for i := 0; i < hosts; i++ {
+		resources = append(resources, generatedResource{
+			Mode: "managed",
+			Type: "aws_instance",
+			Name: fmt.Sprintf("web%d", i),
+			Instances: []interface{}{map[string]interface{}{
+				"attributes": map[string]interface{}{
+					"id":         fmt.Sprintf("i-%08d", i),
+					"private_ip": fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+					"user_data":  string(bytes.Repeat([]byte("x"), 4096)),
+					"tags":       map[string]string{"Name": fmt.Sprintf("web%d", i)},
+				},
+			}},
+		}, generatedResource{
+			Mode: "managed",
+			Type: "ansible_host",
+			Name: fmt.Sprintf("web%d", i),
+			Instances: []interface{}{map[string]interface{}{
+				"attributes": map[string]interface{}{
+					"inventory_hostname": fmt.Sprintf("web%d", i),
+					"groups":             []string{fmt.Sprintf("group%d", i%groups), "web"},
+					"vars":               map[string]string{"ansible_host": fmt.Sprintf("10.0.%d.%d", i/256, i%256)},
+				},
+			}},
+		})
+	}
+
+	b, err := json.Marshal(struct {
+		Version          int                 `json:"version"`
+		TerraformVersion string              `json:"terraform_version"`
+		Serial           int64               `json:"serial"`
+		Lineage          string              `json:"lineage"`
+		Resources        []generatedResource `json:"resources"`
+	}{4, "1.5.0", 1, "bench", resources})
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+var benchmarkSizes = []struct {
+	hosts, groups int
+}{
+	{100, 10},
+	{1000, 50},
+	{5000, 200},
+}
+
+func BenchmarkParseState(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		state := generateState(size.hosts, size.groups)
+
+		b.Run(fmt.Sprintf("hosts=%d", size.hosts), func(b *testing.B) {
+			b.SetBytes(int64(len(state)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := parseState(state); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBuildInventory(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		state, err := parseState(generateState(size.hosts, size.groups))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("hosts=%d", size.hosts), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := BuildInventory(state); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
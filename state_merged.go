@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// StateMerged combines several states into a single state. Groups and
+// hosts are merged by name. When a variable is defined more than once,
+// the value from the later state wins.
+type StateMerged struct {
+	States []State
+}
+
+// GetGroups will return the groups of all states.
+func (r StateMerged) GetGroups() ([]string, error) {
+	var groups []string
+
+	for _, s := range r.States {
+		v, err := s.GetGroups()
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, v...)
+	}
+
+	return uniqueSorted(groups), nil
+}
+
+// GetHosts will return the hosts of all states.
+func (r StateMerged) GetHosts() ([]string, error) {
+	var hosts []string
+
+	for _, s := range r.States {
+		v, err := s.GetHosts()
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, v...)
+	}
+
+	return uniqueSorted(hosts), nil
+}
+
+// GetGroup will return the group from the first state which defines it.
+func (r StateMerged) GetGroup(group string) (interface{}, error) {
+	for _, s := range r.States {
+		if v, err := s.GetGroup(group); err == nil {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unable to find group %s", group)
+}
+
+// GetHost will return the host from the first state which defines it.
+func (r StateMerged) GetHost(host string) (interface{}, error) {
+	for _, s := range r.States {
+		if v, err := s.GetHost(host); err == nil {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unable to find host %s", host)
+}
+
+// GetChildrenForGroup will return the children of a group across all
+// states which define it.
+func (r StateMerged) GetChildrenForGroup(group string) ([]string, error) {
+	var children []string
+
+	states, err := r.statesWithGroup(group)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range states {
+		v, err := s.GetChildrenForGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, v...)
+	}
+
+	return uniqueSorted(children), nil
+}
+
+// GetVarsForGroup will return the variables of a group across all states
+// which define it.
+func (r StateMerged) GetVarsForGroup(group string) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+
+	states, err := r.statesWithGroup(group)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range states {
+		v, err := s.GetVarsForGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range v {
+			vars[k] = v
+		}
+	}
+
+	return vars, nil
+}
+
+// GetHostsForGroup will return the hosts that belong to a group across
+// all states.
+func (r StateMerged) GetHostsForGroup(group string) ([]string, error) {
+	var hosts []string
+
+	for _, s := range r.States {
+		v, err := s.GetHostsForGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, v...)
+	}
+
+	return uniqueSorted(hosts), nil
+}
+
+// GetGroupsForHost will return the groups of a host across all states
+// which define it.
+func (r StateMerged) GetGroupsForHost(host string) ([]string, error) {
+	groups := []string{}
+	seen := make(map[string]bool)
+
+	states, err := r.statesWithHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range states {
+		v, err := s.GetGroupsForHost(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range v {
+			if !seen[g] {
+				seen[g] = true
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// GetVarsForHost will return the variables of a host across all states
+// which define it.
+func (r StateMerged) GetVarsForHost(host string) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+
+	states, err := r.statesWithHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range states {
+		v, err := s.GetVarsForHost(host)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range v {
+			vars[k] = v
+		}
+	}
+
+	return vars, nil
+}
+
+// GetRevisions will return the revisions of all states.
+func (r StateMerged) GetRevisions() []StateRevision {
+	var revisions []StateRevision
+
+	for _, s := range r.States {
+		revisions = append(revisions, s.GetRevisions()...)
+	}
+
+	return revisions
+}
+
+func (r StateMerged) statesWithGroup(group string) ([]State, error) {
+	var states []State
+
+	for _, s := range r.States {
+		if _, err := s.GetGroup(group); err == nil {
+			states = append(states, s)
+		}
+	}
+
+	if len(states) == 0 {
+		return nil, fmt.Errorf("Unable to find group %s", group)
+	}
+
+	return states, nil
+}
+
+func (r StateMerged) statesWithHost(host string) ([]State, error) {
+	var states []State
+
+	for _, s := range r.States {
+		if _, err := s.GetHost(host); err == nil {
+			states = append(states, s)
+		}
+	}
+
+	if len(states) == 0 {
+		return nil, fmt.Errorf("Unable to find host %s", host)
+	}
+
+	return states, nil
+}
+
+// uniqueSorted returns the sorted, de-duplicated values of s.
+func uniqueSorted(s []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// mergeStates combines states into a single state. Missing (nil) states
+// are skipped and nil is returned if no states remain.
+func mergeStates(states []State) State {
+	var result []State
+
+	for _, s := range states {
+		if s != nil {
+			result = append(result, s)
+		}
+	}
+
+	switch len(result) {
+	case 0:
+		return nil
+	case 1:
+		return result[0]
+	}
+
+	return StateMerged{States: result}
+}
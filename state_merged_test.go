@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateMergedV012_same(t *testing.T) {
+	state := mergeStates([]State{expectedStateV012, nil, expectedStateV012})
+
+	actualInventory, err := BuildInventory(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both states are listed, even though they are the same revision.
+	meta := actualInventory["_meta"].(map[string]interface{})
+	assert.Equal(t, []StateRevision{
+		{Serial: 12, Lineage: "9b8b06a2-914e-26df-58d1-c5146f4d8e25"},
+		{Serial: 12, Lineage: "9b8b06a2-914e-26df-58d1-c5146f4d8e25"},
+	}, meta["terraform_states"])
+
+	meta["terraform_states"] = expectedStateV012.GetRevisions()
+	assert.Equal(t, expectedInventoryV012, actualInventory)
+}
+
+func TestStateMergedV012_distinct(t *testing.T) {
+	network := StateV012{
+		Serial:  3,
+		Lineage: "network",
+		Resources: []ResourceV012{
+			ResourceV012{
+				Type: "ansible_group",
+				Name: "web",
+				Instances: []InstanceV012{
+					InstanceV012{
+						Attributes: map[string]interface{}{
+							"name":     "web",
+							"children": []interface{}{"app"},
+							"variables": map[string]interface{}{
+								"foo": "bar",
+							},
+						},
+					},
+				},
+			},
+			ResourceV012{
+				Type: "ansible_host",
+				Name: "bastion",
+				Instances: []InstanceV012{
+					InstanceV012{
+						Attributes: map[string]interface{}{
+							"name": "bastion",
+							"variables": map[string]interface{}{
+								"ansible_host": "10.0.0.1",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	compute := StateV012{
+		Serial:  7,
+		Lineage: "compute",
+		Resources: []ResourceV012{
+			ResourceV012{
+				Type: "ansible_group",
+				Name: "web",
+				Instances: []InstanceV012{
+					InstanceV012{
+						Attributes: map[string]interface{}{
+							"name":     "web",
+							"children": []interface{}{"api"},
+							"variables": map[string]interface{}{
+								"foo": "baz",
+							},
+						},
+					},
+				},
+			},
+			ResourceV012{
+				Type: "ansible_host",
+				Name: "web_1",
+				Instances: []InstanceV012{
+					InstanceV012{
+						Attributes: map[string]interface{}{
+							"name":   "web_1",
+							"groups": []interface{}{"web"},
+							"variables": map[string]interface{}{
+								"ansible_host": "10.0.1.1",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	expected := map[string]interface{}{
+		"web": map[string]interface{}{
+			"hosts":    []string{"web_1"},
+			"children": []string{"api", "app"},
+			"vars": map[string]interface{}{
+				"foo": "baz",
+			},
+		},
+		"ungrouped": map[string]interface{}{
+			"hosts": []string{"bastion"},
+			"vars":  map[string]interface{}{},
+		},
+		"all": map[string]interface{}{
+			"hosts": []string{"bastion", "web_1"},
+			"vars":  map[string]interface{}{},
+		},
+		"_meta": map[string]interface{}{
+			"terraform_states": []StateRevision{
+				{Serial: 3, Lineage: "network"},
+				{Serial: 7, Lineage: "compute"},
+			},
+			"hostvars": map[string]interface{}{
+				"bastion": map[string]interface{}{
+					"ansible_host": "10.0.0.1",
+				},
+				"web_1": map[string]interface{}{
+					"ansible_host": "10.0.1.1",
+				},
+			},
+		},
+	}
+
+	actualInventory, err := BuildInventory(mergeStates([]State{network, compute}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expected, actualInventory)
+}
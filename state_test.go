@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateRevision_Matches(t *testing.T) {
+	r := StateRevision{Serial: 12, Lineage: "9b8b06a2-914e-26df-58d1-c5146f4d8e25"}
+
+	assert.True(t, r.Matches(r))
+	assert.True(t, r.Matches(StateRevision{Serial: 12}))
+	assert.False(t, r.Matches(StateRevision{Serial: 13, Lineage: r.Lineage}))
+	assert.False(t, r.Matches(StateRevision{Serial: 12, Lineage: "other"}))
+}
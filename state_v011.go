@@ -1,17 +1,139 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
 )
 
+func init() {
+	// Versions 1 and 2 of the state, written by Terraform 0.6 and prior,
+	// share the layout of modules and resources with version 3.
+	RegisterStateParser(1, parseStateV011)
+	RegisterStateParser(2, parseStateV011)
+	RegisterStateParser(3, parseStateV011)
+}
+
+// stateV011Decoder decodes a state written by Terraform 0.11 and prior.
+// Only the ansible_* resources are decoded.
+type stateV011Decoder struct {
+	state StateV011
+}
+
+func parseStateV011() StateDecoder {
+	return &stateV011Decoder{}
+}
+
+// DecodeField decodes the serial, the lineage and the modules.
+func (d *stateV011Decoder) DecodeField(dec *json.Decoder, key string) error {
+	switch key {
+	case "serial":
+		return dec.Decode(&d.state.Serial)
+	case "lineage":
+		return dec.Decode(&d.state.Lineage)
+	case "modules":
+		return decodeArray(dec, func() error {
+			return d.decodeModule(dec)
+		})
+	}
+
+	return skipValue(dec)
+}
+
+// decodeModule decodes the path and the ansible_* resources of a module.
+func (d *stateV011Decoder) decodeModule(dec *json.Decoder) error {
+	m := ModuleV011{Resources: make(map[string]ResourceV011)}
+
+	err := decodeObject(dec, func(key string) error {
+		switch key {
+		case "path":
+			return dec.Decode(&m.Path)
+		case "resources":
+			return decodeObject(dec, func(name string) error {
+				return decodeResourceV011(dec, name, m.Resources)
+			})
+		}
+
+		return skipValue(dec)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	d.state.Modules = append(d.state.Modules, m)
+	return nil
+}
+
+// decodeResourceV011 decodes a resource into resources if it is an
+// ansible_* resource, and skips it otherwise.
+func decodeResourceV011(dec *json.Decoder, name string, resources map[string]ResourceV011) error {
+	var (
+		r    ResourceV011
+		used *bool
+		raw  json.RawMessage
+	)
+
+	// isUsed reports whether the resource is used, once its type is known.
+	// Data sources do not define hosts or groups.
+	isUsed := func() *bool {
+		if r.Type == "" {
+			return nil
+		}
+
+		ok := isAnsibleResource(r.Type) && !strings.HasPrefix(name, "data.")
+		return &ok
+	}
+
+	err := decodeObject(dec, func(key string) error {
+		switch key {
+		case "type":
+			return dec.Decode(&r.Type)
+		case "primary":
+			used = isUsed()
+			return decodeResourceValue(dec, used, &r.Primary, &raw)
+		}
+
+		return skipValue(dec)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if used = isUsed(); used == nil || !*used {
+		return nil
+	}
+
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &r.Primary); err != nil {
+			return err
+		}
+	}
+
+	resources[name] = r
+	return nil
+}
+
+// State returns the decoded state.
+func (d *stateV011Decoder) State() State {
+	return d.state
+}
+
 // The following structs are for Terraform State
 // from version v0.11 and prior.
 type StateV011 struct {
+	Serial  int64        `json:"serial"`
+	Lineage string       `json:"lineage"`
 	Modules []ModuleV011 `json:"modules"`
 }
 
+// GetRevisions will return the serial and lineage of the state.
+func (r StateV011) GetRevisions() []StateRevision {
+	return []StateRevision{{Serial: r.Serial, Lineage: r.Lineage}}
+}
+
 // GetGroups will return all ansible_group resources.
 func (r StateV011) GetGroups() ([]string, error) {
 	var groups []string
@@ -179,7 +301,9 @@ func (r StateV011) GetGroupsForHost(host string) ([]string, error) {
 	return groups, nil
 }
 
-// GetVarsForHost will return the variables defined in an ansible_host resource.
+// GetVarsForHost will return the variables defined in an ansible_host
+// resource, the module of the resource as terraform_module and whether it
+// is tainted as terraform_tainted.
 func (r StateV011) GetVarsForHost(host string) (map[string]interface{}, error) {
 	var resource ResourceV011
 	vars := make(map[string]interface{})
@@ -204,10 +328,24 @@ func (r StateV011) GetVarsForHost(host string) (map[string]interface{}, error) {
 		}
 	}
 
-	return vars, nil
+	return terraformVars(vars, r.hostModule(host), resource.Primary.Tainted), nil
+}
+
+// hostModule will return the module address of an ansible_host resource.
+func (r StateV011) hostModule(host string) string {
+	for _, m := range r.Modules {
+		for _, resource := range m.Resources {
+			if resource.Type == "ansible_host" && resource.Primary.ID == host {
+				return moduleAddress(m.Path)
+			}
+		}
+	}
+
+	return ""
 }
 
 type ModuleV011 struct {
+	Path      []string                `json:"path"`
 	Resources map[string]ResourceV011 `json:"resources"`
 }
 
@@ -219,4 +357,5 @@ type ResourceV011 struct {
 type PrimaryV011 struct {
 	ID         string            `json:"id"`
 	Attributes map[string]string `json:"attributes"`
+	Tainted    bool              `json:"tainted,omitempty"`
 }
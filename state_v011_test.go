@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 var expectedStateV011 = StateV011{
+	Serial:  1,
+	Lineage: "16015fe5-5b24-330c-ca11-e3630a674808",
 	Modules: []ModuleV011{
 		ModuleV011{
+			Path: []string{"root"},
 			Resources: map[string]ResourceV011{
 				"ansible_host.host_1": ResourceV011{
 					Type: "ansible_host",
@@ -147,6 +151,7 @@ var expectedStateV011 = StateV011{
 			},
 		},
 		{
+			Path: []string{"root", "more_hosts"},
 			Resources: map[string]ResourceV011{
 				"ansible_host.host_5": ResourceV011{
 					Type: "ansible_host",
@@ -198,6 +203,9 @@ var expectedInventoryV011 = map[string]interface{}{
 		"vars":  map[string]interface{}{},
 	},
 	"_meta": map[string]interface{}{
+		"terraform_states": []StateRevision{
+			{Serial: 1, Lineage: "16015fe5-5b24-330c-ca11-e3630a674808"},
+		},
 		"hostvars": map[string]interface{}{
 			"host_1": map[string]interface{}{
 				"ansible_host": "1.2.3.4",
@@ -218,8 +226,9 @@ var expectedInventoryV011 = map[string]interface{}{
 				"ansible_user": "ubuntu",
 			},
 			"host_5": map[string]interface{}{
-				"ansible_host": "1.2.3.8",
-				"ansible_user": "ubuntu",
+				"terraform_module": "module.more_hosts",
+				"ansible_host":     "1.2.3.8",
+				"ansible_user":     "ubuntu",
 			},
 			"some_host_0": map[string]interface{}{
 				"ansible_host": "1.2.4.0",
@@ -234,7 +243,7 @@ var expectedInventoryV011 = map[string]interface{}{
 }
 
 func TestStateV011_basic(t *testing.T) {
-	actual, err := getState("fixtures/v011")
+	actual, err := getStateFromSource(context.Background(), &CommandSource{Command: command, Dir: "fixtures/v011"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -277,3 +286,19 @@ func TestStateV011_basic(t *testing.T) {
 
 	assert.Equal(t, expectedInventoryV011, actualInventory)
 }
+
+func TestStateV011_stateFile(t *testing.T) {
+	actual, err := getStateFromSource(context.Background(), &FileSource{Path: "fixtures/v011/terraform.tfstate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedStateV011, actual)
+
+	actualInventory, err := BuildInventory(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedInventoryV011, actualInventory)
+}
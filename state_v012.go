@@ -1,16 +1,116 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
 )
 
+func init() {
+	RegisterStateParser(4, parseStateV012)
+}
+
+// stateV012Decoder decodes a state written by Terraform 0.12 and later, or
+// by OpenTofu. Only the instances of ansible_* resources are decoded.
+type stateV012Decoder struct {
+	state StateV012
+}
+
+func parseStateV012() StateDecoder {
+	return &stateV012Decoder{}
+}
+
+// DecodeField decodes the serial, the lineage and the resources.
+func (d *stateV012Decoder) DecodeField(dec *json.Decoder, key string) error {
+	switch key {
+	case "serial":
+		return dec.Decode(&d.state.Serial)
+	case "lineage":
+		return dec.Decode(&d.state.Lineage)
+	case "resources":
+		return decodeArray(dec, func() error {
+			return d.decodeResource(dec)
+		})
+	}
+
+	return skipValue(dec)
+}
+
+// decodeResource decodes a resource. Only the instances of ansible_*
+// resources are decoded, the others are skipped.
+func (d *stateV012Decoder) decodeResource(dec *json.Decoder) error {
+	var (
+		r    ResourceV012
+		mode string
+		used *bool
+		raw  json.RawMessage
+	)
+
+	// isUsed reports whether the resource is used, once its type is known.
+	// Data sources do not define hosts or groups.
+	isUsed := func() *bool {
+		if r.Type == "" {
+			return nil
+		}
+
+		ok := isAnsibleResource(r.Type) && mode != "data"
+		return &ok
+	}
+
+	err := decodeObject(dec, func(key string) error {
+		switch key {
+		case "module":
+			return dec.Decode(&r.Module)
+		case "mode":
+			return dec.Decode(&mode)
+		case "type":
+			return dec.Decode(&r.Type)
+		case "name":
+			return dec.Decode(&r.Name)
+		case "instances":
+			used = isUsed()
+			return decodeResourceValue(dec, used, &r.Instances, &raw)
+		}
+
+		return skipValue(dec)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if used = isUsed(); used == nil || !*used {
+		return nil
+	}
+
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &r.Instances); err != nil {
+			return err
+		}
+	}
+
+	d.state.Resources = append(d.state.Resources, r)
+	return nil
+}
+
+// State returns the decoded state.
+func (d *stateV012Decoder) State() State {
+	return d.state
+}
+
 // The following structs are for Terraform State
 // for version v0.12.
 type StateV012 struct {
+	Serial    int64          `json:"serial"`
+	Lineage   string         `json:"lineage"`
 	Resources []ResourceV012 `json:"resources"`
 }
 
+// GetRevisions will return the serial and lineage of the state.
+func (r StateV012) GetRevisions() []StateRevision {
+	return []StateRevision{{Serial: r.Serial, Lineage: r.Lineage}}
+}
+
 // GetGroups will return all ansible_group resources.
 func (r StateV012) GetGroups() ([]string, error) {
 	var groups []string
@@ -202,7 +302,9 @@ func (r StateV012) GetGroupsForHost(host string) ([]string, error) {
 	return groups, nil
 }
 
-// GetVarsForHost will return the variables defined in an ansible_host resource.
+// GetVarsForHost will return the variables defined in an ansible_host
+// resource, the module of the resource as terraform_module and whether it
+// is tainted as terraform_tainted.
 func (r StateV012) GetVarsForHost(host string) (map[string]interface{}, error) {
 	var instance InstanceV012
 	vars := make(map[string]interface{})
@@ -221,15 +323,36 @@ func (r StateV012) GetVarsForHost(host string) (map[string]interface{}, error) {
 		vars = v
 	}
 
-	return vars, nil
+	return terraformVars(vars, r.hostModule(host), instance.Status == statusTainted), nil
+}
+
+// hostModule will return the module address of an ansible_host resource.
+func (r StateV012) hostModule(host string) string {
+	for _, resource := range r.Resources {
+		if resource.Type == "ansible_host" {
+			for _, instance := range resource.Instances {
+				if v, ok := instance.Attributes["inventory_hostname"].(string); ok && v == host {
+					return resource.Module
+				}
+				if v, ok := instance.Attributes["name"].(string); ok && v == host {
+					return resource.Module
+				}
+			}
+		}
+	}
+
+	return ""
 }
 
 type ResourceV012 struct {
+	Module    string         `json:"module,omitempty"`
 	Type      string         `json:"type"`
 	Name      string         `json:"name"`
 	Instances []InstanceV012 `json:"instances"`
 }
 
 type InstanceV012 struct {
+	Status     string                 `json:"status,omitempty"`
+	Deposed    string                 `json:"deposed,omitempty"`
 	Attributes map[string]interface{} `json:"attributes"`
 }
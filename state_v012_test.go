@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 var expectedStateV012 = StateV012{
+	Serial:  12,
+	Lineage: "9b8b06a2-914e-26df-58d1-c5146f4d8e25",
 	Resources: []ResourceV012{
 		{
 			Name: "group_1",
@@ -131,8 +135,9 @@ var expectedStateV012 = StateV012{
 			},
 		},
 		{
-			Name: "host_5",
-			Type: "ansible_host",
+			Module: "module.more_hosts",
+			Name:   "host_5",
+			Type:   "ansible_host",
 			Instances: []InstanceV012{
 				{
 					Attributes: map[string]interface{}{
@@ -148,8 +153,9 @@ var expectedStateV012 = StateV012{
 			},
 		},
 		{
-			Name: "host_6",
-			Type: "ansible_host",
+			Module: "module.more_hosts",
+			Name:   "host_6",
+			Type:   "ansible_host",
 			Instances: []InstanceV012{
 				{
 					Attributes: map[string]interface{}{
@@ -196,6 +202,8 @@ var expectedStateV012 = StateV012{
 }
 
 var expectedStateV012AnsibleAnsible = StateV012{
+	Serial:  12,
+	Lineage: "9b8b06a2-914e-26df-58d1-c5146f4d8e25",
 	Resources: []ResourceV012{
 		{
 			Name: "group_1",
@@ -320,8 +328,9 @@ var expectedStateV012AnsibleAnsible = StateV012{
 			},
 		},
 		{
-			Name: "host_5",
-			Type: "ansible_host",
+			Module: "module.more_hosts",
+			Name:   "host_5",
+			Type:   "ansible_host",
 			Instances: []InstanceV012{
 				{
 					Attributes: map[string]interface{}{
@@ -337,8 +346,9 @@ var expectedStateV012AnsibleAnsible = StateV012{
 			},
 		},
 		{
-			Name: "host_6",
-			Type: "ansible_host",
+			Module: "module.more_hosts",
+			Name:   "host_6",
+			Type:   "ansible_host",
 			Instances: []InstanceV012{
 				{
 					Attributes: map[string]interface{}{
@@ -416,6 +426,9 @@ var expectedInventoryV012 = map[string]interface{}{
 		"vars":  map[string]interface{}{},
 	},
 	"_meta": map[string]interface{}{
+		"terraform_states": []StateRevision{
+			{Serial: 12, Lineage: "9b8b06a2-914e-26df-58d1-c5146f4d8e25"},
+		},
 		"hostvars": map[string]interface{}{
 			"host_1": map[string]interface{}{
 				"ansible_host": "1.2.3.4",
@@ -436,12 +449,14 @@ var expectedInventoryV012 = map[string]interface{}{
 				"ansible_user": "ubuntu",
 			},
 			"host_5": map[string]interface{}{
-				"ansible_host": "1.2.3.8",
-				"ansible_user": "ubuntu",
+				"terraform_module": "module.more_hosts",
+				"ansible_host":     "1.2.3.8",
+				"ansible_user":     "ubuntu",
 			},
 			"host_6": map[string]interface{}{
-				"ansible_host": "1.2.3.9",
-				"ansible_user": "ubuntu",
+				"terraform_module": "module.more_hosts",
+				"ansible_host":     "1.2.3.9",
+				"ansible_user":     "ubuntu",
 			},
 			"some_host_0": map[string]interface{}{
 				"ansible_host": "1.2.4.0",
@@ -463,7 +478,7 @@ var fixtures_states = map[string]StateV012{
 func TestStateV012_basic(t *testing.T) {
 	for fixture, state := range fixtures_states {
 		t.Run(fixture, func(t *testing.T) {
-			actual, err := getState(fixture)
+			actual, err := getStateFromSource(context.Background(), &CommandSource{Command: command, Dir: fixture})
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -508,3 +523,38 @@ func TestStateV012_basic(t *testing.T) {
 		})
 	}
 }
+
+func TestStateV012_stateFile(t *testing.T) {
+	for fixture, state := range fixtures_states {
+		t.Run(fixture, func(t *testing.T) {
+			actual, err := getStateFromSource(context.Background(), &FileSource{Path: fixture + "/terraform.tfstate"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, state, actual)
+
+			actualInventory, err := BuildInventory(actual)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, expectedInventoryV012, actualInventory)
+		})
+	}
+}
+
+func TestStateV012_stdin(t *testing.T) {
+	f, err := os.Open("fixtures/v012/nbering-ansible/terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	actual, err := getStateFromSource(context.Background(), &ReaderSource{Name: "stdin", Reader: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedStateV012, actual)
+}
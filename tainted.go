@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+)
+
+// statusTainted is the status of an instance in a version 4 state which
+// failed to be created or was marked to be replaced.
+const statusTainted = "tainted"
+
+// getIncludeTainted reports whether tainted hosts and groups are included,
+// given with --include-tainted or TF_INVENTORY_INCLUDE_TAINTED.
+func getIncludeTainted() bool {
+	return *includeTainted || os.Getenv("TF_INVENTORY_INCLUDE_TAINTED") != ""
+}
+
+// filterInstances returns the state without the instances which are about
+// to be destroyed: deposed instances, which are left over from a
+// create_before_destroy replacement, and tainted instances unless they are
+// included. Tainted hosts which are included have terraform_tainted set.
+func filterInstances(state State, includeTainted bool) State {
+	switch s := state.(type) {
+	case StateV012:
+		var resources []ResourceV012
+		for _, r := range s.Resources {
+			var instances []InstanceV012
+			for _, instance := range r.Instances {
+				if instance.Deposed != "" || (instance.Status == statusTainted && !includeTainted) {
+					continue
+				}
+				instances = append(instances, instance)
+			}
+
+			if len(instances) > 0 {
+				r.Instances = instances
+				resources = append(resources, r)
+			}
+		}
+		s.Resources = resources
+		return s
+
+	case StateV011:
+		var modules []ModuleV011
+		for _, m := range s.Modules {
+			resources := make(map[string]ResourceV011)
+			for name, r := range m.Resources {
+				// A resource whose only objects are deposed has no
+				// primary.
+				if r.Primary.ID == "" || (r.Primary.Tainted && !includeTainted) {
+					continue
+				}
+				resources[name] = r
+			}
+			m.Resources = resources
+			modules = append(modules, m)
+		}
+		s.Modules = modules
+		return s
+	}
+
+	return state
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testStateV4Tainted = `{
+  "version": 4,
+  "terraform_version": "1.5.0",
+  "serial": 3,
+  "lineage": "tainted",
+  "resources": [
+    {
+      "mode": "data",
+      "type": "ansible_host",
+      "name": "lookup",
+      "instances": [{"attributes": {"inventory_hostname": "lookup"}}]
+    },
+    {
+      "mode": "managed",
+      "type": "ansible_host",
+      "name": "web",
+      "instances": [
+        {"attributes": {"inventory_hostname": "web", "groups": ["app"]}},
+        {"deposed": "00000001", "attributes": {"inventory_hostname": "old_web", "groups": ["app"]}}
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "ansible_host",
+      "name": "db",
+      "instances": [
+        {"status": "tainted", "attributes": {"inventory_hostname": "db", "groups": ["app"]}}
+      ]
+    }
+  ]
+}`
+
+const testStateV3Tainted = `{
+  "version": 3,
+  "terraform_version": "0.11.14",
+  "serial": 3,
+  "lineage": "tainted",
+  "modules": [
+    {
+      "path": ["root"],
+      "resources": {
+        "data.ansible_host.lookup": {
+          "type": "ansible_host",
+          "primary": {"id": "lookup", "attributes": {}}
+        },
+        "ansible_host.web": {
+          "type": "ansible_host",
+          "primary": {"id": "web", "attributes": {"groups.#": "1", "groups.0": "app"}}
+        },
+        "ansible_host.old_web": {
+          "type": "ansible_host",
+          "primary": null,
+          "deposed": [{"id": "old_web", "attributes": {"groups.#": "1", "groups.0": "app"}}]
+        },
+        "ansible_host.db": {
+          "type": "ansible_host",
+          "primary": {"id": "db", "tainted": true, "attributes": {"groups.#": "1", "groups.0": "app"}}
+        }
+      }
+    }
+  ]
+}`
+
+func TestFilterInstances(t *testing.T) {
+	for name, b := range map[string]string{"v3": testStateV3Tainted, "v4": testStateV4Tainted} {
+		state, err := parseState([]byte(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hosts, err := filterInstances(state, false).GetHosts()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"web"}, hosts, name)
+
+		included := filterInstances(state, true)
+		hosts, err = included.GetHostsForGroup("app")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"db", "web"}, hosts, name)
+
+		vars, err := included.GetVarsForHost("db")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"terraform_tainted": true}, vars, name)
+
+		vars, err = included.GetVarsForHost("web")
+		assert.NoError(t, err)
+		assert.Empty(t, vars, name)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	terragruntConfig      = "terragrunt.hcl"
+	terragruntCache       = ".terragrunt-cache"
+	terragruntGroupPrefix = "unit"
+)
+
+// getTerragruntRecursive reports whether the state of every Terragrunt
+// unit below TF_STATE should be read, like `terragrunt run-all`.
+func getTerragruntRecursive() bool {
+	return *recursive || os.Getenv("TF_TERRAGRUNT_RECURSIVE") != ""
+}
+
+// getTerragruntUnitGroups reports whether each Terragrunt unit should be
+// added as a group of its hosts.
+func getTerragruntUnitGroups() bool {
+	return *unitGroups || os.Getenv("TF_TERRAGRUNT_UNIT_GROUPS") != ""
+}
+
+// findTerragruntUnits returns the directories below root which contain a
+// terragrunt.hcl file, in lexical order. The .terragrunt-cache
+// directories are skipped. A terragrunt.hcl in root itself is only a unit
+// if there are no others, as it is usually the parent configuration which
+// the units include.
+func findTerragruntUnits(root string) ([]string, error) {
+	var units []string
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && info.Name() == terragruntCache {
+			return filepath.SkipDir
+		}
+
+		if !info.IsDir() && info.Name() == terragruntConfig {
+			units = append(units, filepath.Dir(path))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Error searching for Terragrunt units in %s: %s", root, err)
+	}
+
+	if len(units) > 1 {
+		var children []string
+		for _, unit := range units {
+			if unit != filepath.Clean(root) {
+				children = append(children, unit)
+			}
+		}
+		units = children
+	}
+
+	return units, nil
+}
+
+// isTerragrunt reports whether the command runs Terragrunt, such as
+// terragrunt or /usr/local/bin/terragrunt.
+func isTerragrunt(command string) bool {
+	return commandName(command) == Terragrunt
+}
+
+// terragruntUnitRequests returns a stateRequest for each Terragrunt unit
+// below root, running the Terragrunt command of base. When grouped is true,
+// the hosts of each unit are added to a group named after the path of the
+// unit, such as unit_prod_vpc.
+func terragruntUnitRequests(base CommandSource, root string, grouped bool) ([]stateRequest, error) {
+	units, err := findTerragruntUnits(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(units) == 0 {
+		return nil, fmt.Errorf("No Terragrunt units found in %s", root)
+	}
+
+	var requests []stateRequest
+	for _, unit := range units {
+		source := base
+		source.Dir = unit
+
+		r := stateRequest{Source: &source}
+
+		if grouped {
+			r.Groups = []string{groupName(terragruntGroupPrefix, unitName(root, unit))}
+		}
+
+		requests = append(requests, r)
+	}
+
+	return requests, nil
+}
+
+// unitName returns the path of a unit relative to root. A unit at the root
+// itself is named after its directory.
+func unitName(root, unit string) string {
+	rel, err := filepath.Rel(root, unit)
+	if err != nil || rel == "." {
+		return filepath.Base(unit)
+	}
+
+	return rel
+}
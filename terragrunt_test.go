@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindTerragruntUnits(t *testing.T) {
+	root, err := ioutil.TempDir("", "terragrunt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{
+		"prod/vpc",
+		"prod/app",
+		"staging/app",
+		"staging/app/.terragrunt-cache/abc/def",
+		"modules/vpc",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if dir == "modules/vpc" {
+			continue
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(root, dir, terragruntConfig), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	units, err := findTerragruntUnits(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{
+		filepath.Join(root, "prod/app"),
+		filepath.Join(root, "prod/vpc"),
+		filepath.Join(root, "staging/app"),
+	}, units)
+
+	requests, err := terragruntUnitRequests(CommandSource{Command: Terragrunt}, root, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var groups []string
+	for _, r := range requests {
+		assert.Equal(t, Terragrunt, r.Source.(*CommandSource).Command)
+		groups = append(groups, r.Groups...)
+	}
+
+	assert.Equal(t, []string{"unit_prod_app", "unit_prod_vpc", "unit_staging_app"}, groups)
+
+	_, err = terragruntUnitRequests(CommandSource{Command: Terragrunt}, filepath.Join(root, "modules"), false)
+	assert.Error(t, err)
+}
+
+func TestFindTerragruntUnits_rootParent(t *testing.T) {
+	root, err := ioutil.TempDir("", "terragrunt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	parent := []byte(`remote_state {
+  backend = "s3"
+  config = {
+    bucket = "state"
+    key    = "${path_relative_to_include()}/terraform.tfstate"
+  }
+}
+`)
+	if err := ioutil.WriteFile(filepath.Join(root, terragruntConfig), parent, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without other units, the root is the unit.
+	units, err := findTerragruntUnits(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{root}, units)
+
+	child := []byte(`include "root" {
+  path = find_in_parent_folders()
+}
+`)
+	if err := os.MkdirAll(filepath.Join(root, "prod/app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "prod/app", terragruntConfig), child, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	units, err = findTerragruntUnits(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{filepath.Join(root, "prod/app")}, units)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const defaultTFCHostname = "app.terraform.io"
+
+// TFCSource reads the current state version of a Terraform Cloud or
+// Terraform Enterprise workspace through the API.
+type TFCSource struct {
+	Hostname     string
+	Organization string
+	Workspace    string
+	Token        string
+
+	Client *http.Client
+}
+
+func init() {
+	RegisterStateSource("tfc", newTFCSourceFromURL)
+}
+
+// newTFCSourceFromURL creates a TFCSource from a URL such as
+// tfc://app.terraform.io/organization/workspace. The hostname can be
+// omitted: tfc:///organization/workspace. The token is read from the
+// environment.
+func newTFCSourceFromURL(u *url.URL) (StateSource, error) {
+	pieces := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(pieces) != 2 {
+		return nil, fmt.Errorf("Both an organization and workspace must be specified in %s", u)
+	}
+
+	s := newTFCSourceFromEnv()
+	if u.Host != "" {
+		s.Hostname = u.Host
+	}
+	s.Organization = pieces[0]
+	s.Workspace = pieces[1]
+
+	return s, nil
+}
+
+// newTFCSourceFromEnv creates a TFCSource from the environment. The
+// workspace defaults to the one selected with TF_WORKSPACE.
+func newTFCSourceFromEnv() *TFCSource {
+	s := &TFCSource{
+		Hostname:     os.Getenv("TF_CLOUD_HOSTNAME"),
+		Organization: os.Getenv("TF_CLOUD_ORGANIZATION"),
+		Workspace:    os.Getenv("TF_CLOUD_WORKSPACE"),
+		Token:        os.Getenv("TFE_TOKEN"),
+	}
+
+	if s.Workspace == "" {
+		s.Workspace = getWorkspace()
+	}
+
+	if s.Hostname == "" {
+		s.Hostname = defaultTFCHostname
+	}
+
+	return s
+}
+
+// BaseURL returns the base URL of the API. Hostname may include a scheme,
+// otherwise https is used.
+func (s *TFCSource) BaseURL() string {
+	h := strings.TrimSuffix(s.Hostname, "/")
+	if !strings.Contains(h, "://") {
+		h = "https://" + h
+	}
+
+	return h + "/api/v2"
+}
+
+// Fetch resolves the current state version of the workspace and
+// downloads it. If the workspace has no state, no bytes and no error are
+// returned.
+func (s *TFCSource) Fetch(ctx context.Context) ([]byte, StateMetadata, error) {
+	meta := StateMetadata{
+		Source:    fmt.Sprintf("tfc://%s/%s/%s", s.Hostname, s.Organization, s.Workspace),
+		Workspace: s.Workspace,
+	}
+
+	v, found, err := s.currentStateVersion(ctx)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	// The workspace does not have a state yet.
+	if !found {
+		return nil, meta, nil
+	}
+
+	downloadURL := v.HostedStateDownloadURL
+	if downloadURL == "" {
+		return nil, meta, fmt.Errorf("The current state version of %s/%s has no download URL", s.Organization, s.Workspace)
+	}
+
+	b, _, err := s.get(ctx, downloadURL)
+	if err != nil {
+		return nil, meta, err
+	}
+
+	return b, meta, nil
+}
+
+// FetchRevision returns the serial and lineage of the current state
+// version without downloading the state.
+func (s *TFCSource) FetchRevision(ctx context.Context) (StateRevision, bool, error) {
+	v, found, err := s.currentStateVersion(ctx)
+	if err != nil || !found {
+		return StateRevision{}, false, err
+	}
+
+	return StateRevision{Serial: v.Serial, Lineage: v.Lineage}, true, nil
+}
+
+// tfcStateVersion holds the attributes of a state version.
+type tfcStateVersion struct {
+	HostedStateDownloadURL string `json:"hosted-state-download-url"`
+	Serial                 int64  `json:"serial"`
+	Lineage                string `json:"lineage"`
+}
+
+// currentStateVersion returns the current state version of the workspace.
+// It returns false if the workspace does not have a state yet.
+func (s *TFCSource) currentStateVersion(ctx context.Context) (tfcStateVersion, bool, error) {
+	var v tfcStateVersion
+
+	if s.Organization == "" || s.Workspace == "" {
+		return v, false, fmt.Errorf("Both a Terraform Cloud organization and workspace must be specified")
+	}
+
+	if s.Token == "" {
+		return v, false, fmt.Errorf("A Terraform Cloud API token must be specified with TFE_TOKEN")
+	}
+
+	var workspace struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+
+	u := fmt.Sprintf("%s/organizations/%s/workspaces/%s",
+		s.BaseURL(), url.PathEscape(s.Organization), url.PathEscape(s.Workspace))
+	found, err := s.getJSON(ctx, u, &workspace)
+	if err != nil {
+		return v, false, err
+	}
+
+	if !found {
+		return v, false, fmt.Errorf("Unable to find workspace %s/%s", s.Organization, s.Workspace)
+	}
+
+	var stateVersion struct {
+		Data struct {
+			Attributes tfcStateVersion `json:"attributes"`
+		} `json:"data"`
+	}
+
+	u = fmt.Sprintf("%s/workspaces/%s/current-state-version", s.BaseURL(), url.PathEscape(workspace.Data.ID))
+	found, err = s.getJSON(ctx, u, &stateVersion)
+	if err != nil || !found {
+		return v, false, err
+	}
+
+	return stateVersion.Data.Attributes, true, nil
+}
+
+// getJSON requests u and decodes the response into v. It returns false if
+// the resource was not found.
+func (s *TFCSource) getJSON(ctx context.Context, u string, v interface{}) (bool, error) {
+	b, status, err := s.get(ctx, u)
+	if err != nil {
+		if status == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("Error decoding response of %s: %s", u, err)
+	}
+
+	return true, nil
+}
+
+// get performs an authenticated GET request and returns the body and the
+// status code of the response.
+func (s *TFCSource) get(ctx context.Context, u string) ([]byte, int, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error building request for %s: %s", u, err)
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	req.Header.Set("Content-Type", "application/vnd.api+json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error requesting %s: %s", u, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("Error reading response of %s: %s", u, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, newStatusError(resp, b, "Error requesting %s", u)
+	}
+
+	return b, resp.StatusCode, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTFCV012_fetch(t *testing.T) {
+	b, err := ioutil.ReadFile("fixtures/v012/ansible-ansible/terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v2/organizations/acme/workspaces/web":
+			fmt.Fprint(w, `{"data": {"id": "ws-web", "type": "workspaces"}}`)
+		case "/api/v2/organizations/acme/workspaces/empty":
+			fmt.Fprint(w, `{"data": {"id": "ws-empty", "type": "workspaces"}}`)
+		case "/api/v2/workspaces/ws-web/current-state-version":
+			fmt.Fprintf(w, `{"data": {"id": "sv-1", "attributes": {"serial": 12, "hosted-state-download-url": "%s/download/sv-1"}}}`, ts.URL)
+		case "/download/sv-1":
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	s := &TFCSource{
+		Hostname:     ts.URL,
+		Organization: "acme",
+		Workspace:    "web",
+		Token:        "token",
+	}
+
+	actual, _, err := s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := parseState(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedStateV012AnsibleAnsible, state)
+
+	revision, found, err := s.FetchRevision(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, found)
+	assert.True(t, revision.Matches(state.GetRevisions()[0]))
+
+	s.Workspace = "empty"
+	actual, _, err = s.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, actual)
+
+	s.Workspace = "missing"
+	_, _, err = s.Fetch(context.Background())
+	assert.Error(t, err)
+}
+
+func TestNewTFCSourceFromEnv(t *testing.T) {
+	defer setenv("TF_CLOUD_WORKSPACE", "")()
+	defer setenv("TF_WORKSPACE", "staging")()
+	assert.Equal(t, "staging", newTFCSourceFromEnv().Workspace)
+
+	// A list of workspaces is read by getWorkspaces.
+	defer setenv("TF_WORKSPACE", "staging,prod")()
+	assert.Equal(t, defaultWorkspace, newTFCSourceFromEnv().Workspace)
+
+	defer setenv("TF_CLOUD_WORKSPACE", "app-prod")()
+	assert.Equal(t, "app-prod", newTFCSourceFromEnv().Workspace)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const workspaceGroupPrefix = "workspace"
+
+// Interface WorkspaceSource represents a StateSource whose backend stores
+// the states of several workspaces.
+type WorkspaceSource interface {
+	StateSource
+
+	// ListWorkspaces returns the names of all workspaces.
+	ListWorkspaces(ctx context.Context) ([]string, error)
+
+	// ForWorkspace returns a StateSource for the state of a workspace.
+	ForWorkspace(workspace string) StateSource
+}
+
+// Interface WorkspaceBatchSource represents a WorkspaceSource which can
+// read the states of several workspaces with a single request.
+type WorkspaceBatchSource interface {
+	WorkspaceSource
+
+	// ForWorkspaces returns a StateSource for the state of each workspace.
+	// The states are read together when the first of them is fetched.
+	ForWorkspaces(workspaces []string) []StateSource
+}
+
+// getWorkspace returns the Terraform workspace to read state for. A list
+// of workspaces in TF_WORKSPACE is returned by getWorkspaces instead.
+func getWorkspace() string {
+	if v := os.Getenv("TF_WORKSPACE"); v != "" && !isWorkspaceList(v) {
+		return v
+	}
+
+	return defaultWorkspace
+}
+
+// isWorkspaceList reports whether a TF_WORKSPACE value selects several
+// workspaces.
+func isWorkspaceList(v string) bool {
+	return v == "*" || strings.Contains(v, ",")
+}
+
+// getWorkspaces returns the workspaces to merge into one inventory, given
+// with --workspaces or TF_WORKSPACES as a comma-separated list or "*" for
+// all workspaces. A list or "*" in TF_WORKSPACE is accepted as well.
+func getWorkspaces() []string {
+	v := *workspaces
+	if v == "" {
+		v = os.Getenv("TF_WORKSPACES")
+	}
+
+	if v == "" {
+		if w := os.Getenv("TF_WORKSPACE"); isWorkspaceList(w) {
+			v = w
+		}
+	}
+
+	var result []string
+	for _, w := range strings.Split(v, ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			result = append(result, w)
+		}
+	}
+
+	return result
+}
+
+// getWorkspaceGroups reports whether each workspace should be added as a
+// group of its hosts.
+func getWorkspaceGroups() bool {
+	return *workspaceGroups || os.Getenv("TF_WORKSPACE_GROUPS") != ""
+}
+
+// workspaceRequests returns a stateRequest for each of the workspaces of
+// the source. If names is "*", all workspaces of the source are used.
+func workspaceRequests(ctx context.Context, source StateSource, names []string, grouped bool) ([]stateRequest, error) {
+	ws, ok := source.(WorkspaceSource)
+	if !ok {
+		return nil, fmt.Errorf("The state source does not support multiple workspaces")
+	}
+
+	if len(names) == 1 && names[0] == "*" {
+		err := retryPolicy.Do(ctx, func() error {
+			var err error
+			names, err = ws.ListWorkspaces(ctx)
+			return err
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sources := make([]StateSource, len(names))
+	if batch, ok := ws.(WorkspaceBatchSource); ok {
+		sources = batch.ForWorkspaces(names)
+	} else {
+		for i, name := range names {
+			sources[i] = ws.ForWorkspace(name)
+		}
+	}
+
+	var requests []stateRequest
+	for i, name := range names {
+		r := stateRequest{Source: sources[i], Workspace: name}
+		if grouped {
+			r.Groups = []string{groupName(workspaceGroupPrefix, name)}
+		}
+
+		requests = append(requests, r)
+	}
+
+	return requests, nil
+}
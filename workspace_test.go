@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixtureWorkspaceSource serves a fixture state for every workspace.
+type fixtureWorkspaceSource struct {
+	FileSource
+	Workspaces []string
+}
+
+func (s *fixtureWorkspaceSource) ListWorkspaces(ctx context.Context) ([]string, error) {
+	return s.Workspaces, nil
+}
+
+func (s *fixtureWorkspaceSource) ForWorkspace(workspace string) StateSource {
+	return &s.FileSource
+}
+
+// batchWorkspaceSource records the workspaces whose states are read
+// together.
+type batchWorkspaceSource struct {
+	fixtureWorkspaceSource
+	Batches [][]string
+}
+
+func (s *batchWorkspaceSource) ForWorkspaces(workspaces []string) []StateSource {
+	s.Batches = append(s.Batches, workspaces)
+
+	sources := make([]StateSource, len(workspaces))
+	for i := range workspaces {
+		sources[i] = &s.FileSource
+	}
+
+	return sources
+}
+
+func TestParseWorkspaceList(t *testing.T) {
+	out := "  default\n* staging\n  production\n\n"
+	assert.Equal(t, []string{"default", "staging", "production"}, parseWorkspaceList(out))
+}
+
+func TestWorkspacesV012_grouped(t *testing.T) {
+	source := &fixtureWorkspaceSource{
+		FileSource: FileSource{Path: "fixtures/v012/nbering-ansible/terraform.tfstate"},
+		Workspaces: []string{"default", "staging-eu"},
+	}
+
+	ctx := context.Background()
+
+	requests, err := workspaceRequests(ctx, source, []string{"*"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := getStates(ctx, requests, defaultParallelism)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := mergeStates(states)
+
+	inv, err := BuildInventory(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allHosts := []string{
+		"host_1", "host_2", "host_3", "host_4", "host_5", "host_6",
+		"some_host_0", "some_host_1",
+	}
+
+	for _, group := range []string{"workspace_default", "workspace_staging_eu"} {
+		assert.Equal(t, map[string]interface{}{
+			"hosts": allHosts,
+			"vars":  map[string]interface{}{},
+		}, inv[group])
+	}
+
+	// Hosts without any other group are no longer ungrouped.
+	_, ok := inv["ungrouped"]
+	assert.False(t, ok)
+
+	assert.Equal(t, expectedInventoryV012["group_1"], inv["group_1"])
+	meta := inv["_meta"].(map[string]interface{})
+	assert.Equal(t, expectedInventoryV012["_meta"].(map[string]interface{})["hostvars"], meta["hostvars"])
+	assert.Len(t, meta["terraform_states"], 2)
+}
+
+func TestWorkspaces_unsupported(t *testing.T) {
+	defer setenv("TF_STATE_FILE", "fixtures/v012/nbering-ansible/terraform.tfstate")()
+	defer setenv("TF_WORKSPACES", "*")()
+
+	_, err := loadState(context.Background())
+	assert.Error(t, err)
+}
+
+func TestWorkspaceRequests_batch(t *testing.T) {
+	source := &batchWorkspaceSource{fixtureWorkspaceSource: fixtureWorkspaceSource{
+		FileSource: FileSource{Path: "fixtures/v012/nbering-ansible/terraform.tfstate"},
+		Workspaces: []string{"default", "staging"},
+	}}
+
+	requests, err := workspaceRequests(context.Background(), source, []string{"*"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, [][]string{{"default", "staging"}}, source.Batches)
+	assert.Len(t, requests, 2)
+	assert.Equal(t, "staging", requests[1].Workspace)
+}
+
+func TestGetWorkspace(t *testing.T) {
+	defer setenv("TF_WORKSPACES", "")()
+	defer setenv("TF_WORKSPACE", "staging")()
+	assert.Equal(t, "staging", getWorkspace())
+	assert.Nil(t, getWorkspaces())
+
+	defer setenv("TF_WORKSPACE", "default, staging")()
+	assert.Equal(t, defaultWorkspace, getWorkspace())
+	assert.Equal(t, []string{"default", "staging"}, getWorkspaces())
+
+	defer setenv("TF_WORKSPACE", "*")()
+	assert.Equal(t, defaultWorkspace, getWorkspace())
+	assert.Equal(t, []string{"*"}, getWorkspaces())
+
+	// The pg source reads the list through getWorkspaces as well.
+	assert.Equal(t, defaultWorkspace, newPGSourceFromEnv().Workspace)
+}